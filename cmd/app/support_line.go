@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log/slog"
 	"os"
 
@@ -17,7 +18,16 @@ func main() {
 		log, 
 		config,
 	)
-	go app.Bot.RemoveTopics()
+	if flag.Arg(0) == "export" {
+		err := app.Bot.Export(flag.Args()[1:])
+		if err != nil {
+			log.Error("Export transcripts", "Error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	go app.Bot.Schedule()
 	app.Bot.Register()
 	app.Bot.ListenMessages(config.Server.Host, config.Server.Port)
 }
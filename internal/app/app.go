@@ -1,10 +1,13 @@
 package app
 
 import (
+	"fmt"
 	"log/slog"
 
 	appsupport "github.com/behummble/support_line_bot/internal/app/support_line"
 	"github.com/behummble/support_line_bot/internal/repo/db/redis"
+	"github.com/behummble/support_line_bot/internal/repo/storage/local"
+	"github.com/behummble/support_line_bot/internal/repo/storage/s3"
 	"github.com/behummble/support_line_bot/internal/service/support_line"
 	"github.com/behummble/support_line_bot/internal/websocket/updates"
 	"github.com/behummble/support_line_bot/internal/config"
@@ -25,9 +28,39 @@ func New(log *slog.Logger, config *config.Config) App {
 		panic(err)
 	}
 	
-	botService := supportline.New(log, db, config.Bot.ChatID, config.Bot.UpdateTimeout)
-	router := updates.New(log, botService)
+	storage, err := newStorage(config.Archive)
+	if err != nil {
+		panic(err)
+	}
+
+	botService := supportline.New(
+		log, 
+		db, 
+		storage, 
+		config.Bot.Token, 
+		config.Bot.ChatID, 
+		config.Bot.UpdateTimeout, 
+		config.Support)
+	router := updates.New(log, botService, config.Server.AdminToken)
 	appsupport := appsupport.New(log, botService, router)
 	
 	return App{Bot: appsupport}
-}
\ No newline at end of file
+}
+
+func newStorage(config config.ArchiveConfig) (supportline.Storage, error) {
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case "local":
+		return local.New(config.Dir)
+	case "s3":
+		return s3.New(
+			config.S3.Endpoint,
+			config.S3.Bucket,
+			config.S3.Region,
+			config.S3.AccessKey,
+			config.S3.SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown archive backend %s", config.Backend)
+	}
+}
@@ -0,0 +1,50 @@
+package appsupportline
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/export"
+)
+
+// Export runs the export subcommand:
+// export -chat <id> [-ticket <n> | -from 2026-01-01 -to 2026-01-31] [-format json|csv|html] [-bundle] -out file.
+func (support *Support) Export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	chatID := flags.Int64("chat", 0, "support group chat ID")
+	ticket := flags.Int64("ticket", 0, "ticket number, exports the period when omitted")
+	from := flags.String("from", "", "first day of the period, YYYY-MM-DD")
+	to := flags.String("to", "", "last day of the period, YYYY-MM-DD")
+	format := flags.String("format", export.FormatJSON, "json, csv or html")
+	bundle := flags.Bool("bundle", false, "write a zip archive with the downloaded attachments")
+	out := flags.String("out", "", "output file, required as the logs go to stdout")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *chatID == 0 {
+		return fmt.Errorf("the -chat flag is required")
+	}
+
+	if *out == "" {
+		return fmt.Errorf("the -out flag is required")
+	}
+
+	filter := entity.TranscriptFilter{Ticket: *ticket}
+	filter.From, filter.To, err = export.ParsePeriod(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return support.supportService.Export(file, *chatID, filter, *format, *bundle)
+}
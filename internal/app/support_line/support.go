@@ -35,6 +35,6 @@ func (support *Support) ListenMessages(host string, port int) {
 	support.router.Serve(host, port)
 }
 
-func (support *Support) RemoveTopics() {
-	support.supportService.RemoveTopics()
+func (support *Support) Schedule() {
+	support.supportService.Schedule()
 }
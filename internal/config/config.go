@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"os"
+	"sync"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -11,6 +12,25 @@ type Config struct {
 	Redis RedisConfig `yaml:"redis"`
 	Bot BotConfig `yaml:"bot"`
 	Server ServerConfig `yaml:"server"`
+	Support SupportConfig `yaml:"support"`
+	Archive ArchiveConfig `yaml:"archive"`
+}
+
+// ArchiveConfig selects where topic archives are written: "local" keeps
+// them in Dir, "s3" uploads them to an S3-compatible API, empty disables
+// archiving. The archives are encrypted with the CRYPTO_KEY.
+type ArchiveConfig struct {
+	Backend string `yaml:"backend" env:"ARCHIVE_BACKEND"`
+	Dir string `yaml:"dir" env-default:"./archive"`
+	S3 S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Bucket string `yaml:"bucket" env:"S3_BUCKET"`
+	Region string `yaml:"region" env:"S3_REGION"`
+	AccessKey string `yaml:"accessKey" env:"S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secretKey" env:"S3_SECRET_KEY"`
 }
 
 type RedisConfig struct {
@@ -25,9 +45,237 @@ type BotConfig struct {
 	ChatID int64 `yaml:"chatID" env:"CHAT_ID"`
 }
 
+// ServerConfig keeps the HTTP API address. AdminToken opens the admin
+// endpoints for every tenant, the API is closed when it is empty and the
+// tenant has no APIToken of its own.
 type ServerConfig struct {
 	Host string `yaml:"host"`
 	Port int `yaml:"port"`
+	AdminToken string `yaml:"adminToken" env:"ADMIN_TOKEN"`
+}
+
+// SupportConfig holds per-tenant settings keyed by the support group chat.
+// A tenant entry replaces the default settings as a whole. The aliases map
+// the chats a tenant group migrated to onto its configured chat.
+type SupportConfig struct {
+	Default TenantConfig `yaml:"default"`
+	Tenants []TenantConfig `yaml:"tenants"`
+	aliases *sync.Map
+}
+
+type TenantConfig struct {
+	ChatID int64 `yaml:"chatID"`
+	OpsChatID int64 `yaml:"opsChatID"`
+	Topic TopicConfig `yaml:"topic"`
+	// Assignment is one of manual, round-robin or least-loaded.
+	Assignment string `yaml:"assignment"`
+	Timezone string `yaml:"timezone"`
+	// DutyOverrideHours limits how long /onduty and /offduty override shifts.
+	DutyOverrideHours int `yaml:"dutyOverrideHours"`
+	BusinessHours BusinessHoursConfig `yaml:"businessHours"`
+	AutoReply AutoReplyConfig `yaml:"autoReply"`
+	SLA SLAConfig `yaml:"sla"`
+	Survey SurveyConfig `yaml:"survey"`
+	// NotePrefixes mark agent messages kept in the topic as internal notes.
+	NotePrefixes []string `yaml:"notePrefixes"`
+	Signature SignatureConfig `yaml:"signature"`
+	Transcript TranscriptConfig `yaml:"transcript"`
+	Ban BanConfig `yaml:"ban"`
+	Flood FloodConfig `yaml:"flood"`
+	Triage TriageConfig `yaml:"triage"`
+	FAQ FAQConfig `yaml:"faq"`
+	Suggestions SuggestionsConfig `yaml:"suggestions"`
+	// RepeatContactHours flags the users who come back within this time
+	// after their previous ticket was resolved, zero disables the check.
+	RepeatContactHours int `yaml:"repeatContactHours"`
+	// ArchiveTopicID is the topic where archived tickets are announced.
+	ArchiveTopicID int `yaml:"archiveTopicID"`
+	// APIToken opens the admin endpoints for this tenant only, it is
+	// ignored in the default settings.
+	APIToken string `yaml:"apiToken"`
+}
+
+// BanConfig keeps the reply to banned users by their language, banned
+// users get no reply when it is empty. DeleteTopic removes the topic of
+// the banned user.
+type BanConfig struct {
+	Reply map[string]string `yaml:"reply"`
+	DeleteTopic bool `yaml:"deleteTopic"`
+}
+
+// TriageConfig asks the user to pick a category and answer its fields
+// before the topic is created. Prompt is keyed by the user language, the
+// field Pattern is a regular expression the answer must match.
+type TriageConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Prompt map[string]string `yaml:"prompt"`
+	TimeoutMinutes int `yaml:"timeoutMinutes"`
+	Categories []TriageCategory `yaml:"categories"`
+}
+
+type TriageCategory struct {
+	ID string `yaml:"id"`
+	Title string `yaml:"title"`
+	Fields []TriageField `yaml:"fields"`
+}
+
+type TriageField struct {
+	Name string `yaml:"name"`
+	Prompt string `yaml:"prompt"`
+	Pattern string `yaml:"pattern"`
+	Error string `yaml:"error"`
+}
+
+// FAQConfig offers up to MaxAnswers knowledge base articles scored at
+// least MinScore (0..1) to the user before the topic is created. The texts
+// are keyed by the user language.
+type FAQConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MaxAnswers int `yaml:"maxAnswers"`
+	MinScore float64 `yaml:"minScore"`
+	TimeoutMinutes int `yaml:"timeoutMinutes"`
+	Intro map[string]string `yaml:"intro"`
+	Thanks map[string]string `yaml:"thanks"`
+	HelpedButton map[string]string `yaml:"helpedButton"`
+	HumanButton map[string]string `yaml:"humanButton"`
+}
+
+// SuggestionsConfig posts up to Limit macros and knowledge base answers
+// scored at least MinScore (0..1) under the user messages in the topic.
+type SuggestionsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Limit int `yaml:"limit"`
+	MinScore float64 `yaml:"minScore"`
+}
+
+// FloodConfig limits how many messages a user may send in the sliding
+// window. Messages over Limit are held back with the slow down reply and
+// delivered in order as the window frees up, at MuteAfter messages the user
+// is muted for MuteMinutes and the held messages are dropped. Identical
+// messages within DuplicateSeconds are dropped with the duplicate reply.
+// Zero values disable the checks.
+type FloodConfig struct {
+	Limit int `yaml:"limit"`
+	WindowSeconds int `yaml:"windowSeconds"`
+	MuteAfter int `yaml:"muteAfter"`
+	MuteMinutes int `yaml:"muteMinutes"`
+	DuplicateSeconds int `yaml:"duplicateSeconds"`
+	SlowDown map[string]string `yaml:"slowDown"`
+	Duplicate map[string]string `yaml:"duplicate"`
+	Muted map[string]string `yaml:"muted"`
+}
+
+// TranscriptConfig limits how long the ticket history is kept after the
+// last message, zero keeps it forever.
+type TranscriptConfig struct {
+	RetentionDays int `yaml:"retentionDays"`
+}
+
+// SignatureConfig tells how agents are shown to users. Mode is one of
+// anonymous, signature or persona. The persona mode uses the agent alias
+// set with /agent persona, falling back to the tenant Persona.
+type SignatureConfig struct {
+	Mode string `yaml:"mode"`
+	Template string `yaml:"template"`
+	Persona string `yaml:"persona"`
+}
+
+// SurveyConfig keeps the satisfaction survey texts by the user language.
+// The comment is taken from the next message only after the user presses
+// CommentButton and gets CommentPrompt.
+type SurveyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Question map[string]string `yaml:"question"`
+	Thanks map[string]string `yaml:"thanks"`
+	CommentButton map[string]string `yaml:"commentButton"`
+	CommentPrompt map[string]string `yaml:"commentPrompt"`
+	CommentMinutes int `yaml:"commentMinutes"`
+}
+
+// SLAConfig keeps response targets by ticket priority. A warning is sent
+// once WarnPercent of the target time has passed. Tickets closed by the
+// nightly purge breach the targets they haven't met yet.
+type SLAConfig struct {
+	WarnPercent int `yaml:"warnPercent"`
+	Targets map[string]SLATarget `yaml:"targets"`
+}
+
+type SLATarget struct {
+	FirstResponseMinutes int `yaml:"firstResponseMinutes"`
+	ResolutionMinutes int `yaml:"resolutionMinutes"`
+}
+
+// AutoReplyConfig keeps reply templates by the user language code,
+// the "default" entry is used for other languages.
+type AutoReplyConfig struct {
+	Greeting map[string]string `yaml:"greeting"`
+	Reminder map[string]string `yaml:"reminder"`
+	ReminderMinutes int `yaml:"reminderMinutes"`
+}
+
+// BusinessHoursConfig is the weekly schedule in the tenant timezone,
+// e.g. "mon: 09:00-18:00". Holidays are dates like 2026-01-01.
+type BusinessHoursConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Week map[string]string `yaml:"week"`
+	Holidays []string `yaml:"holidays"`
+	Reply string `yaml:"reply"`
+	ResponseTime string `yaml:"responseTime"`
+}
+
+// TopicConfig describes how support topics are named. Icons are keyed by
+// "after-hours", ticket priority or status, checked in this order. The
+// color is only applied on creation.
+type TopicConfig struct {
+	NameTemplate string `yaml:"nameTemplate"`
+	Icons map[string]TopicIcon `yaml:"icons"`
+}
+
+type TopicIcon struct {
+	Color int `yaml:"color"`
+	EmojiID string `yaml:"emojiID"`
+}
+
+func (cfg SupportConfig) Tenant(chatID int64) TenantConfig {
+	if cfg.aliases != nil {
+		if configured, ok := cfg.aliases.Load(chatID); ok {
+			chatID = configured.(int64)
+		}
+	}
+
+	for _, tenant := range cfg.Tenants {
+		if tenant.ChatID == chatID {
+			return tenant
+		}
+	}
+
+	return cfg.Default
+}
+
+// Configured tells whether the chat, or the chat it migrated from, has its
+// own tenant entry.
+func (cfg SupportConfig) Configured(chatID int64) bool {
+	if cfg.aliases != nil {
+		if configured, ok := cfg.aliases.Load(chatID); ok {
+			chatID = configured.(int64)
+		}
+	}
+
+	for _, tenant := range cfg.Tenants {
+		if tenant.ChatID == chatID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddAlias makes the chat use the settings of the configured chat.
+func (cfg *SupportConfig) AddAlias(chatID, configuredChatID int64) {
+	if cfg.aliases == nil {
+		cfg.aliases = &sync.Map{}
+	}
+	cfg.aliases.Store(chatID, configuredChatID)
 }
 
 func MustLoad() *Config {
@@ -60,6 +308,7 @@ func loadConfig(path string) *Config {
 	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
 		panic("cannot read config: " + err.Error())
 	}
+	cfg.Support.aliases = &sync.Map{}
 	
 	return &cfg
 }
\ No newline at end of file
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestTenantAlias(t *testing.T) {
+	cfg := SupportConfig{
+		Default: TenantConfig{Timezone: "UTC"},
+		Tenants: []TenantConfig{{ChatID: -100, Timezone: "Europe/Berlin"}},
+	}
+
+	if got := cfg.Tenant(-1001).Timezone; got != "UTC" {
+		t.Fatalf("unknown chat uses %s, want the default settings", got)
+	}
+
+	cfg.AddAlias(-1001, -100)
+	if got := cfg.Tenant(-1001).Timezone; got != "Europe/Berlin" {
+		t.Errorf("migrated chat uses %s, want the settings of the configured chat", got)
+	}
+
+	if got := cfg.Tenant(-100).Timezone; got != "Europe/Berlin" {
+		t.Errorf("configured chat uses %s after the alias was added", got)
+	}
+}
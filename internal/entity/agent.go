@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+type Agent struct {
+	ID int64
+	UserName string
+	Name string
+	Persona string
+}
+
+func NewAgent(id int64, userName, name string) Agent {
+	return Agent{
+		ID: id,
+		UserName: strings.TrimPrefix(userName, "@"),
+		Name: name,
+	}
+}
+
+func NewAgentFromJSON(data []byte) (Agent, error) {
+	var agent Agent
+	err := json.Unmarshal(data, &agent)
+	if err != nil {
+		return Agent{}, err
+	}
+
+	return agent, err
+}
+
+// Key identifies the agent in the tenant roster.
+func (agent Agent) Key() string {
+	if agent.UserName != "" {
+		return strings.ToLower(agent.UserName)
+	}
+	return strconv.FormatInt(agent.ID, 10)
+}
+
+func (agent Agent) DisplayName() string {
+	switch {
+	case agent.Name != "":
+		return agent.Name
+	case agent.UserName != "":
+		return "@" + agent.UserName
+	default:
+		return strconv.FormatInt(agent.ID, 10)
+	}
+}
@@ -0,0 +1,40 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+type Ban struct {
+	UserID int64
+	GroupChatID int64
+	Reason string
+	BannedBy string
+	CreatedAt int64
+	// ExpiresAt is zero for a permanent ban.
+	ExpiresAt int64
+}
+
+func NewBan(userID, groupChatID int64, reason, bannedBy string, createdAt, expiresAt int64) Ban {
+	return Ban{
+		UserID: userID,
+		GroupChatID: groupChatID,
+		Reason: reason,
+		BannedBy: bannedBy,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func NewBanFromJSON(data []byte) (Ban, error) {
+	var ban Ban
+	err := json.Unmarshal(data, &ban)
+	if err != nil {
+		return Ban{}, err
+	}
+
+	return ban, err
+}
+
+func (ban Ban) Active(now int64) bool {
+	return ban.ExpiresAt == 0 || now < ban.ExpiresAt
+}
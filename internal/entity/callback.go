@@ -0,0 +1,71 @@
+package entity
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const callbackSeparator = "|"
+
+// CallbackMessage is the button press. The topic and the sender names are
+// set for the buttons in the support chat.
+type CallbackMessage struct {
+	BotToken string
+	CallbackID string
+	ChatID int64
+	UserID int64
+	MessageID int
+	GroupChatID int64
+	Data string
+	TopicID int
+	UserName string
+	FirstName string
+	LastName string
+}
+
+func NewCallbackMessageFromJSON(data []byte) (CallbackMessage, error) {
+	var msg CallbackMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return CallbackMessage{}, err
+	}
+
+	return msg, err
+}
+
+// NewCallbackData joins the action and its arguments into the button data.
+func NewCallbackData(action string, args ...interface{}) string {
+	parts := []string{action}
+	for _, arg := range args {
+		switch value := arg.(type) {
+		case string:
+			parts = append(parts, value)
+		case int:
+			parts = append(parts, strconv.Itoa(value))
+		case int64:
+			parts = append(parts, strconv.FormatInt(value, 10))
+		}
+	}
+
+	return strings.Join(parts, callbackSeparator)
+}
+
+func (msg CallbackMessage) Action() (string, []string) {
+	parts := strings.Split(msg.Data, callbackSeparator)
+	return parts[0], parts[1:]
+}
+
+// SupportMessage is the agent message the button press stands for.
+func (msg CallbackMessage) SupportMessage() SupportMessage {
+	return SupportMessage{
+		BotToken: msg.BotToken,
+		ChatID: msg.ChatID,
+		TopicID: msg.TopicID,
+		MessageID: msg.MessageID,
+		SenderID: msg.UserID,
+		SenderUserName: msg.UserName,
+		SenderFirstName: msg.FirstName,
+		SenderLastName: msg.LastName,
+	}
+}
@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryFailed = "failed"
+)
+
+type Delivery struct {
+	TopicID int
+	MessageID int
+	Status string
+	Reason string
+	Date int64
+}
+
+func NewDelivery(topicID, messageID int, status, reason string, date int64) Delivery {
+	return Delivery{
+		TopicID: topicID,
+		MessageID: messageID,
+		Status: status,
+		Reason: reason,
+		Date: date,
+	}
+}
+
+func NewDeliveryFromJSON(data []byte) (Delivery, error) {
+	var delivery Delivery
+	err := json.Unmarshal(data, &delivery)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	return delivery, err
+}
@@ -0,0 +1,112 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DutyOn = "on"
+	DutyOff = "off"
+	shiftTimeLayout = "15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type Shift struct {
+	Days []string
+	Start string
+	End string
+}
+
+type Duty struct {
+	Agent Agent
+	OnDuty bool
+	Reason string
+}
+
+func NewShift(days []string, start, end string) (Shift, error) {
+	shift := Shift{
+		Start: start,
+		End: end,
+	}
+
+	for _, day := range days {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if _, ok := weekdays[day]; !ok {
+			return Shift{}, fmt.Errorf("unknown weekday %s", day)
+		}
+		shift.Days = append(shift.Days, day)
+	}
+
+	if _, err := time.Parse(shiftTimeLayout, start); err != nil {
+		return Shift{}, fmt.Errorf("invalid shift start %s", start)
+	}
+
+	if _, err := time.Parse(shiftTimeLayout, end); err != nil {
+		return Shift{}, fmt.Errorf("invalid shift end %s", end)
+	}
+
+	return shift, nil
+}
+
+func Weekday(name string) (time.Weekday, bool) {
+	day, ok := weekdays[strings.ToLower(strings.TrimSpace(name))]
+	return day, ok
+}
+
+func NewShiftsFromJSON(data []byte) ([]Shift, error) {
+	var shifts []Shift
+	err := json.Unmarshal(data, &shifts)
+	if err != nil {
+		return nil, err
+	}
+
+	return shifts, err
+}
+
+// Covers reports whether the moment falls into the shift. A shift that
+// ends before it starts runs past midnight into the next day.
+func (shift Shift) Covers(moment time.Time) bool {
+	start, _ := time.Parse(shiftTimeLayout, shift.Start)
+	end, _ := time.Parse(shiftTimeLayout, shift.End)
+	minute := moment.Hour()*60 + moment.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute <= endMinute {
+		return shift.hasDay(moment.Weekday()) && minute >= startMinute && minute < endMinute
+	}
+
+	return (shift.hasDay(moment.Weekday()) && minute >= startMinute) ||
+		(shift.hasDay((moment.Weekday()+6)%7) && minute < endMinute)
+}
+
+// StartOn returns the moment the shift starts on the day of the given time.
+func (shift Shift) StartOn(day time.Time) time.Time {
+	start, _ := time.Parse(shiftTimeLayout, shift.Start)
+	return time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, day.Location())
+}
+
+func (shift Shift) String() string {
+	return fmt.Sprintf("%s %s-%s", strings.Join(shift.Days, ","), shift.Start, shift.End)
+}
+
+func (shift Shift) hasDay(day time.Weekday) bool {
+	for _, name := range shift.Days {
+		if weekdays[name] == day {
+			return true
+		}
+	}
+	return false
+}
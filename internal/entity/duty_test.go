@@ -0,0 +1,52 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+// 2026-10-19 is a Monday.
+func monday(hour, minute int) time.Time {
+	return time.Date(2026, 10, 19, hour, minute, 0, 0, time.UTC)
+}
+
+func TestDayShiftCovers(t *testing.T) {
+	shift := Shift{Days: []string{"mon"}, Start: "09:00", End: "18:00"}
+
+	if !shift.Covers(monday(9, 0)) || !shift.Covers(monday(12, 0)) {
+		t.Error("the shift doesn't cover its own hours")
+	}
+
+	if shift.Covers(monday(8, 59)) || shift.Covers(monday(18, 0)) {
+		t.Error("the shift covers the minute before the start or the end")
+	}
+
+	if (Shift{Days: []string{"tue"}, Start: "09:00", End: "18:00"}).Covers(monday(12, 0)) {
+		t.Error("the tuesday shift covers monday")
+	}
+
+	if !(Shift{Days: []string{"sun", "mon"}, Start: "09:00", End: "18:00"}).Covers(monday(12, 0)) {
+		t.Error("the shift of several days misses one of them")
+	}
+}
+
+func TestOvernightShiftCovers(t *testing.T) {
+	sunday := Shift{Days: []string{"sun"}, Start: "22:00", End: "02:00"}
+	if !sunday.Covers(monday(1, 0)) {
+		t.Error("the sunday night shift doesn't go on after midnight")
+	}
+	if sunday.Covers(monday(2, 0)) {
+		t.Error("the sunday night shift goes on after its end")
+	}
+
+	shift := Shift{Days: []string{"mon"}, Start: "22:00", End: "02:00"}
+	for _, moment := range []time.Time{monday(1, 0), monday(12, 0)} {
+		if shift.Covers(moment) {
+			t.Errorf("the monday night shift covers %s", moment.Format("Mon 15:04"))
+		}
+	}
+
+	if !shift.Covers(monday(23, 0)) {
+		t.Error("the monday night shift doesn't cover the evening")
+	}
+}
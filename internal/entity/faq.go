@@ -0,0 +1,122 @@
+package entity
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	FAQOffers = "offers"
+	FAQHelped = "helped"
+	FAQEscalated = "escalated"
+	faqStatSeparator = ":"
+)
+
+// Article is a question and answer pair of the tenant knowledge base.
+type Article struct {
+	ID string
+	Question string
+	Answer string
+	Keywords []string
+}
+
+// FAQState is the offer of the articles to the user without a topic, the
+// Triage keeps the messages to forward when the user asks for a human.
+type FAQState struct {
+	Triage TriageState
+	Articles []string
+}
+
+type ArticleStats struct {
+	Offered int
+	Helped int
+}
+
+// FAQReport counts the offers of the knowledge base articles, the
+// deflection rate is the share of the offers that helped the user.
+type FAQReport struct {
+	Offers int
+	Helped int
+	Escalated int
+	DeflectionRate float64
+	Articles map[string]ArticleStats
+}
+
+func NewArticle(id, question, answer string, keywords []string) Article {
+	return Article{
+		ID: id,
+		Question: question,
+		Answer: answer,
+		Keywords: keywords,
+	}
+}
+
+func NewArticleFromJSON(data []byte) (Article, error) {
+	var article Article
+	err := json.Unmarshal(data, &article)
+	if err != nil {
+		return Article{}, err
+	}
+
+	return article, err
+}
+
+func NewFAQStateFromJSON(data []byte) (FAQState, error) {
+	var state FAQState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return FAQState{}, err
+	}
+
+	return state, err
+}
+
+// ArticleStat is the stats field of the article counter.
+func ArticleStat(counter, id string) string {
+	return counter + faqStatSeparator + id
+}
+
+// NewFAQReport sums up the daily FAQ stats.
+func NewFAQReport(days []map[string]string) FAQReport {
+	report := FAQReport{
+		Articles: make(map[string]ArticleStats),
+	}
+
+	for _, stats := range days {
+		for field, value := range stats {
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+
+			counter, id, isArticle := strings.Cut(field, faqStatSeparator)
+			if !isArticle {
+				switch counter {
+				case FAQOffers:
+					report.Offers += count
+				case FAQHelped:
+					report.Helped += count
+				case FAQEscalated:
+					report.Escalated += count
+				}
+				continue
+			}
+
+			article := report.Articles[id]
+			switch counter {
+			case FAQOffers:
+				article.Offered += count
+			case FAQHelped:
+				article.Helped += count
+			}
+			report.Articles[id] = article
+		}
+	}
+
+	if report.Offers > 0 {
+		report.DeflectionRate = float64(report.Helped) / float64(report.Offers)
+	}
+
+	return report
+}
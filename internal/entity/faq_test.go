@@ -0,0 +1,43 @@
+package entity
+
+import "testing"
+
+func TestNewFAQReport(t *testing.T) {
+	if report := NewFAQReport(nil); report.Articles == nil || report.DeflectionRate != 0 {
+		t.Fatalf("report without stats %+v, want no rate and an empty article map", report)
+	}
+
+	report := NewFAQReport([]map[string]string{
+		{
+			FAQOffers: "1",
+			FAQHelped: "1",
+			FAQEscalated: "2",
+			ArticleStat(FAQOffers, "refund"): "3",
+			ArticleStat(FAQHelped, "refund"): "1",
+		},
+		{
+			FAQOffers: "3",
+			FAQHelped: "x",
+			ArticleStat(FAQHelped, "refund"): "2",
+			ArticleStat(FAQOffers, "delivery"): "2",
+			"unknown": "5",
+		},
+	})
+
+	if report.Offers != 4 || report.Helped != 1 || report.Escalated != 2 {
+		t.Errorf("totals %d offers, %d helped, %d escalated, want the days summed without the broken counter",
+			report.Offers, report.Helped, report.Escalated)
+	}
+
+	if report.DeflectionRate != 0.25 {
+		t.Errorf("deflection rate %v, want 0.25", report.DeflectionRate)
+	}
+
+	if got := report.Articles["refund"]; got != (ArticleStats{Offered: 3, Helped: 3}) {
+		t.Errorf("refund article %+v, want 3 offers and 3 helped", got)
+	}
+
+	if len(report.Articles) != 2 {
+		t.Errorf("articles %v, want refund and delivery only", report.Articles)
+	}
+}
@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+const (
+	HistoryUser = "user"
+	HistoryAgent = "agent"
+	HistoryNote = "note"
+	MessageText = "text"
+)
+
+// HistoryEntry is a record of the ticket history kept after the topic is gone.
+// Kind is the direction of the message: from the user, from the agent or
+// an internal note. Text holds the message text or the media caption.
+type HistoryEntry struct {
+	Kind string
+	Ticket int64
+	MessageID int
+	AuthorID int64
+	Author string
+	Type string
+	Text string
+	FileIDs []string
+	Date int64
+}
+
+func NewHistoryEntry(kind string, ticket int64, messageID int, author Agent, text string, date int64) HistoryEntry {
+	return HistoryEntry{
+		Kind: kind,
+		Ticket: ticket,
+		MessageID: messageID,
+		AuthorID: author.ID,
+		Author: author.DisplayName(),
+		Type: MessageText,
+		Text: text,
+		Date: date,
+	}
+}
+
+func NewUserHistoryEntry(ticket int64, msg UserMessage, date int64) HistoryEntry {
+	entry := HistoryEntry{
+		Kind: HistoryUser,
+		Ticket: ticket,
+		MessageID: int(msg.MessageID),
+		AuthorID: msg.UserID,
+		Author: msg.UserName,
+		Type: msg.Type,
+		Text: msg.Payload,
+		FileIDs: msg.FileIDs,
+		Date: date,
+	}
+
+	if entry.Type == "" {
+		entry.Type = MessageText
+	}
+
+	if entry.Text == "" {
+		entry.Text = msg.Caption
+	}
+
+	return entry
+}
+
+func NewHistoryEntryFromJSON(data []byte) (HistoryEntry, error) {
+	var entry HistoryEntry
+	err := json.Unmarshal(data, &entry)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	return entry, err
+}
@@ -0,0 +1,29 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+type Macro struct {
+	Name string
+	Text string
+	Status string
+}
+
+func NewMacro(name, text, status string) Macro {
+	return Macro{
+		Name: name,
+		Text: text,
+		Status: status,
+	}
+}
+
+func NewMacroFromJSON(data []byte) (Macro, error) {
+	var macro Macro
+	err := json.Unmarshal(data, &macro)
+	if err != nil {
+		return Macro{}, err
+	}
+
+	return macro, err
+}
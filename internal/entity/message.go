@@ -2,6 +2,7 @@ package entity
 
 import (
 	"encoding/json"
+	"strings"
 )
 
 type UserMessage struct {
@@ -9,7 +10,15 @@ type UserMessage struct {
 	ChatID int64
 	UserID int64
 	UserName string
+	FirstName string
+	LastName string
+	LanguageCode string
+	IsPremium bool
+	Source string
 	Payload string
+	Type string
+	Caption string
+	FileIDs []string
 	MessageID int64
 	GroupChatID int64
 }
@@ -20,6 +29,11 @@ type SupportMessage struct {
 	TopicID int
 	Payload string
 	MessageID int
+	ReplyToMessageID int
+	SenderID int64
+	SenderUserName string
+	SenderFirstName string
+	SenderLastName string
 }
 
 func NewUserMessage(token string, chatID, userID, messageID, groupChatID int64, name, payload string) UserMessage {
@@ -52,6 +66,11 @@ func NewSupportMessage(token string, chatID int64, topicID int, Payload string)
 	}
 }
 
+func (msg SupportMessage) Sender() Agent {
+	name := strings.TrimSpace(msg.SenderFirstName + " " + msg.SenderLastName)
+	return NewAgent(msg.SenderID, msg.SenderUserName, name)
+}
+
 func NewSupportMessageFromJSON(data []byte) (SupportMessage, error) {
 	var msg SupportMessage
 	err := json.Unmarshal(data, &msg)
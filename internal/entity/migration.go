@@ -0,0 +1,12 @@
+package entity
+
+// KeyMove moves a stored key of the tenant to the migrated chat. A key
+// without Value is renamed, otherwise the new key is written with Value and
+// the old one is removed. A move without From only writes the new key.
+// Listed keys are replaced in the topic list as well.
+type KeyMove struct {
+	From string
+	To string
+	Value string
+	Listed bool
+}
@@ -0,0 +1,96 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+type UserProfile struct {
+	UserID int64
+	UserName string
+	FirstName string
+	LastName string
+	LanguageCode string
+	IsPremium bool
+	FirstContact int64
+	TicketCount int
+	LastTicket int64
+	LastTicketAt int64
+	LastCSAT int
+	Tags []string
+	Notes string
+}
+
+func NewUserProfile(msg UserMessage, firstContact int64) UserProfile {
+	return UserProfile{
+		UserID: msg.UserID,
+		UserName: msg.UserName,
+		FirstName: msg.FirstName,
+		LastName: msg.LastName,
+		LanguageCode: msg.LanguageCode,
+		IsPremium: msg.IsPremium,
+		FirstContact: firstContact,
+	}
+}
+
+func NewUserProfileFromJSON(data []byte) (UserProfile, error) {
+	var profile UserProfile
+	err := json.Unmarshal(data, &profile)
+	if err != nil {
+		return UserProfile{}, err
+	}
+
+	return profile, err
+}
+
+// Tag adds the tags the profile doesn't have yet.
+func (profile *UserProfile) Tag(tags ...string) {
+	for _, tag := range tags {
+		if !profile.HasTag(tag) {
+			profile.Tags = append(profile.Tags, tag)
+		}
+	}
+}
+
+func (profile *UserProfile) Untag(tags ...string) {
+	kept := profile.Tags[:0]
+	for _, existing := range profile.Tags {
+		removed := false
+		for _, tag := range tags {
+			if existing == tag {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, existing)
+		}
+	}
+	profile.Tags = kept
+}
+
+func (profile UserProfile) HasTag(tag string) bool {
+	for _, existing := range profile.Tags {
+		if existing == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Update copies the user details from the message and reports whether
+// any of them changed.
+func (profile *UserProfile) Update(msg UserMessage) bool {
+	changed := profile.UserName != msg.UserName ||
+		profile.FirstName != msg.FirstName ||
+		profile.LastName != msg.LastName ||
+		profile.LanguageCode != msg.LanguageCode ||
+		profile.IsPremium != msg.IsPremium
+
+	profile.UserName = msg.UserName
+	profile.FirstName = msg.FirstName
+	profile.LastName = msg.LastName
+	profile.LanguageCode = msg.LanguageCode
+	profile.IsPremium = msg.IsPremium
+
+	return changed
+}
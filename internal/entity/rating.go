@@ -0,0 +1,79 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+type Rating struct {
+	Ticket int64
+	GroupChatID int64
+	UserID int64
+	Agent string
+	Score int
+	Comment string
+	Date int64
+}
+
+type AgentCSAT struct {
+	Count int
+	Average float64
+}
+
+type CSATReport struct {
+	Count int
+	Average float64
+	Distribution map[int]int
+	Agents map[string]AgentCSAT
+}
+
+func NewRating(ticket Ticket, score int, date int64) Rating {
+	return Rating{
+		Ticket: ticket.Number,
+		GroupChatID: ticket.GroupChatID,
+		UserID: ticket.UserID,
+		Agent: ticket.Assignee,
+		Score: score,
+		Date: date,
+	}
+}
+
+func NewRatingFromJSON(data []byte) (Rating, error) {
+	var rating Rating
+	err := json.Unmarshal(data, &rating)
+	if err != nil {
+		return Rating{}, err
+	}
+
+	return rating, err
+}
+
+func NewCSATReport(ratings []Rating) CSATReport {
+	report := CSATReport{
+		Distribution: make(map[int]int),
+		Agents: make(map[string]AgentCSAT),
+	}
+
+	total := 0
+	agentTotals := make(map[string]int)
+	for _, rating := range ratings {
+		report.Count++
+		total += rating.Score
+		report.Distribution[rating.Score]++
+
+		agent := report.Agents[rating.Agent]
+		agent.Count++
+		agentTotals[rating.Agent] += rating.Score
+		report.Agents[rating.Agent] = agent
+	}
+
+	if report.Count > 0 {
+		report.Average = float64(total) / float64(report.Count)
+	}
+
+	for name, agent := range report.Agents {
+		agent.Average = float64(agentTotals[name]) / float64(agent.Count)
+		report.Agents[name] = agent
+	}
+
+	return report
+}
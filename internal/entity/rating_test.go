@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCSATReport(t *testing.T) {
+	empty := NewCSATReport(nil)
+	if empty.Count != 0 || empty.Average != 0 || empty.Distribution == nil || empty.Agents == nil {
+		t.Fatalf("empty report %+v, want zero values and empty maps for JSON", empty)
+	}
+
+	report := NewCSATReport([]Rating{
+		{Agent: "anna", Score: 5},
+		{Agent: "boris", Score: 2},
+		{Agent: "boris", Score: 3},
+		{Score: 2},
+	})
+
+	if report.Count != 4 || report.Average != 3 {
+		t.Errorf("report has %d ratings with average %v, want 4 and 3", report.Count, report.Average)
+	}
+
+	if want := map[int]int{5: 1, 3: 1, 2: 2}; !reflect.DeepEqual(report.Distribution, want) {
+		t.Errorf("distribution %v, want %v", report.Distribution, want)
+	}
+
+	if got := report.Agents["boris"]; got != (AgentCSAT{Count: 2, Average: 2.5}) {
+		t.Errorf("boris has %+v, want 2 ratings with average 2.5", got)
+	}
+
+	if got, ok := report.Agents[""]; !ok || got.Count != 1 {
+		t.Errorf("unassigned tickets are %+v, want them counted under an empty agent", got)
+	}
+}
@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"time"
+)
+
+// SearchQuery matches the tickets containing every word of the text.
+// User is the user ID or @username.
+type SearchQuery struct {
+	Text string
+	User string
+	Status string
+	From time.Time
+	To time.Time
+	Limit int
+}
+
+type SearchResult struct {
+	Ticket int64
+	Status string
+	UserID int64
+	UserName string
+	CreatedAt int64
+	Link string
+	Snippet string
+}
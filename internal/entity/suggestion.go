@@ -0,0 +1,16 @@
+package entity
+
+const (
+	SuggestionMacro = "macro"
+	SuggestionFAQ = "faq"
+)
+
+// Suggestion is a reply proposed to the agents, ID is the macro name or
+// the knowledge base article id depending on the Kind.
+type Suggestion struct {
+	Kind string
+	ID string
+	Title string
+	Text string
+	Score float64
+}
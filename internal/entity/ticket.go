@@ -0,0 +1,155 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	TicketOpen = "open"
+	TicketPendingUser = "pending-user"
+	TicketPendingAgent = "pending-agent"
+	TicketResolved = "resolved"
+	TicketClosed = "closed"
+	PriorityNormal = "normal"
+)
+
+var ticketPriorities = []string{"low", PriorityNormal, "high", "urgent"}
+
+var ticketTransitions = map[string][]string{
+	TicketOpen: {TicketPendingUser, TicketPendingAgent, TicketResolved, TicketClosed},
+	TicketPendingUser: {TicketOpen, TicketPendingAgent, TicketResolved, TicketClosed},
+	TicketPendingAgent: {TicketOpen, TicketPendingUser, TicketResolved, TicketClosed},
+	TicketResolved: {TicketOpen, TicketPendingAgent, TicketClosed},
+	TicketClosed: {TicketOpen, TicketPendingAgent},
+}
+
+type Ticket struct {
+	Number int64
+	GroupChatID int64
+	ChatID int64
+	UserID int64
+	TopicID int
+	Source string
+	// BotToken is the encrypted token of the bot that received the ticket,
+	// its file IDs can only be downloaded with this bot.
+	BotToken string
+	PreviousTicket int64
+	RepeatContact bool
+	Category string
+	Answers []TriageAnswer
+	Status string
+	Priority string
+	Assignee string
+	AfterHours bool
+	Reminded bool
+	SurveySent bool
+	CreatedAt int64
+	UpdatedAt int64
+	FirstMessageAt int64
+	FirstResponseAt int64
+	ResolvedAt int64
+	ArchivedAt int64
+	Archive string
+	SLAAlerts []string
+}
+
+func NewTicket(number, groupChatID, chatID, userID int64, topicID int, source string, date int64) Ticket {
+	return Ticket{
+		Number: number,
+		GroupChatID: groupChatID,
+		ChatID: chatID,
+		UserID: userID,
+		TopicID: topicID,
+		Source: source,
+		Status: TicketOpen,
+		Priority: PriorityNormal,
+		CreatedAt: date,
+		UpdatedAt: date,
+		FirstMessageAt: date,
+	}
+}
+
+func NewTicketFromJSON(data []byte) (Ticket, error) {
+	var ticket Ticket
+	err := json.Unmarshal(data, &ticket)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return ticket, err
+}
+
+func CanTransition(from, to string) bool {
+	for _, status := range ticketTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the ticket still needs the agents' work.
+func (ticket Ticket) Active() bool {
+	return ticket.Status != TicketResolved && ticket.Status != TicketClosed
+}
+
+func (ticket *Ticket) Transition(status string, date int64) error {
+	if !CanTransition(ticket.Status, status) {
+		return fmt.Errorf("ticket #%d can't move from %s to %s", ticket.Number, ticket.Status, status)
+	}
+
+	ticket.Status = status
+	ticket.UpdatedAt = date
+	if ticket.Active() {
+		ticket.ResolvedAt = 0
+	} else if ticket.ResolvedAt == 0 {
+		ticket.ResolvedAt = date
+	}
+
+	return nil
+}
+
+func IsStatus(status string) bool {
+	_, ok := ticketTransitions[status]
+	return ok
+}
+
+func IsPriority(priority string) bool {
+	for _, known := range ticketPriorities {
+		if known == priority {
+			return true
+		}
+	}
+	return false
+}
+
+func (ticket Ticket) FirstResponseTime() time.Duration {
+	if ticket.FirstResponseAt == 0 {
+		return 0
+	}
+	return time.Duration(ticket.FirstResponseAt-ticket.FirstMessageAt) * time.Second
+}
+
+func (ticket Ticket) ResolutionTime() time.Duration {
+	if ticket.ResolvedAt == 0 {
+		return 0
+	}
+	return time.Duration(ticket.ResolvedAt-ticket.FirstMessageAt) * time.Second
+}
+
+func (ticket Ticket) HasSLAAlert(alert string) bool {
+	for _, sent := range ticket.SLAAlerts {
+		if sent == alert {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutToken returns the copy of the ticket to export or archive.
+func (ticket Ticket) WithoutToken() Ticket {
+	ticket.BotToken = ""
+	return ticket
+}
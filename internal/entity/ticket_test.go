@@ -0,0 +1,59 @@
+package entity
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	if CanTransition(TicketOpen, TicketOpen) {
+		t.Error("the ticket can move to the status it already has")
+	}
+
+	if CanTransition(TicketClosed, TicketResolved) {
+		t.Error("a closed ticket can be resolved without being reopened")
+	}
+
+	if CanTransition("archived", TicketOpen) {
+		t.Error("an unknown status can be left")
+	}
+
+	for status := range ticketTransitions {
+		if status != TicketOpen && !CanTransition(status, TicketOpen) {
+			t.Errorf("a %s ticket can't be reopened", status)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	ticket := NewTicket(7, -100, 1, 1, 0, "telegram", 100)
+
+	err := ticket.Transition(TicketResolved, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.ResolvedAt != 200 || ticket.UpdatedAt != 200 {
+		t.Fatalf("resolved ticket has ResolvedAt %d and UpdatedAt %d, want 200", ticket.ResolvedAt, ticket.UpdatedAt)
+	}
+
+	err = ticket.Transition(TicketClosed, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.ResolvedAt != 200 {
+		t.Errorf("closing moved ResolvedAt to %d, want it kept at the resolution time", ticket.ResolvedAt)
+	}
+
+	err = ticket.Transition(TicketResolved, 400)
+	if err == nil {
+		t.Fatal("a closed ticket was resolved again")
+	}
+	if ticket.Status != TicketClosed || ticket.UpdatedAt != 300 {
+		t.Errorf("the rejected transition changed the ticket to %s at %d", ticket.Status, ticket.UpdatedAt)
+	}
+
+	err = ticket.Transition(TicketPendingAgent, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ticket.Active() || ticket.ResolvedAt != 0 {
+		t.Errorf("reopened ticket is active %t with ResolvedAt %d", ticket.Active(), ticket.ResolvedAt)
+	}
+}
@@ -10,15 +10,19 @@ type TopicData struct {
 	UserID int64
 	TopicID int
 	GroupChatID int64
+	Ticket int64
+	CardMessageID int
 }
 
-func NewTopic(token string, chatID, userID, groupChatID int64, topicID int) TopicData {
+func NewTopic(token string, chatID, userID, groupChatID int64, topicID int, ticket int64, cardMessageID int) TopicData {
 	return TopicData{
 		BotToken: token,
 		ChatID: chatID,
 		UserID: userID,
 		TopicID: topicID,
 		GroupChatID: groupChatID,
+		Ticket: ticket,
+		CardMessageID: cardMessageID,
 	}
 }
 
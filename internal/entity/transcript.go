@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"time"
+)
+
+type Transcript struct {
+	Ticket Ticket
+	Entries []HistoryEntry
+}
+
+// TranscriptFilter selects a single ticket or the tickets created in the period.
+type TranscriptFilter struct {
+	Ticket int64
+	From time.Time
+	To time.Time
+}
+
+// FileIDs lists the attachments of the transcript in the order they were sent.
+func (transcript Transcript) FileIDs() []string {
+	var ids []string
+	for _, entry := range transcript.Entries {
+		ids = append(ids, entry.FileIDs...)
+	}
+	return ids
+}
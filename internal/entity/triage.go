@@ -0,0 +1,35 @@
+package entity
+
+import (
+	"encoding/json"
+)
+
+type TriageAnswer struct {
+	Name string
+	Value string
+}
+
+// TriageState is the progress of the user through the triage before the
+// topic is created. Pending keeps the messages to forward to the topic.
+type TriageState struct {
+	Category string
+	Field int
+	Answers []TriageAnswer
+	Pending []UserMessage
+}
+
+func NewTriageState(msg UserMessage) TriageState {
+	return TriageState{
+		Pending: []UserMessage{msg},
+	}
+}
+
+func NewTriageStateFromJSON(data []byte) (TriageState, error) {
+	var state TriageState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return TriageState{}, err
+	}
+
+	return state, err
+}
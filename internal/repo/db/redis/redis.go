@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/behummble/support_line_bot/internal/entity"
 )
 
+const maxUpdateAttempts = 5
+
 type Client struct {
 	log *slog.Logger
 	conn *redis.Client
@@ -46,14 +51,560 @@ func (client Client) NewTopic(ctx context.Context, topicUserKey, topicSupportKey
 	return err
 }
 
-func (client Client) ClearTopics(ctx context.Context) error {
-	_, err := client.conn.FlushAll(ctx).Result()
+func (client Client) RemoveTopic(ctx context.Context, topicSupportKey, topicListKey string, relatedKeys ...string) error {
+	pipe := client.conn.TxPipeline()
+	pipe.Del(ctx, append(relatedKeys, topicSupportKey)...)
+	pipe.LRem(ctx, topicListKey, 0, topicSupportKey)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ClearTopics deletes the topic keys, it used to flush the whole database
+// when it kept nothing but topics.
+func (client Client) ClearTopics(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := client.conn.Del(ctx, keys...).Result()
+	return err
+}
+
+func (client Client) Profile(ctx context.Context, key string) (string, error) {
+	res, err := client.conn.Get(ctx, key).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetProfile(ctx context.Context, key, profile string) error {
+	return client.set(ctx, key, profile)
+}
+
+func (client Client) Ticket(ctx context.Context, key string) (string, error) {
+	res, err := client.conn.Get(ctx, key).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetTicket(ctx context.Context, key, ticket string) error {
+	return client.set(ctx, key, ticket)
+}
+
+// UpdateTicket stores the changed ticket only if nobody else changed it
+// since it was read, otherwise the change is applied again to the new value.
+func (client Client) UpdateTicket(ctx context.Context, key string, change func(ticket string) (string, error)) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := client.conn.Watch(ctx, func(tx *redis.Tx) error {
+			ticket, err := tx.Get(ctx, key).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+
+			updated, err := change(ticket)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, updated, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return redis.TxFailedErr
+}
+
+func (client Client) AddReply(ctx context.Context, key string, date int64) error {
+	_, err := client.conn.RPush(ctx, key, date).Result()
+	return err
+}
+
+func (client Client) Replies(ctx context.Context, key string) ([]string, error) {
+	return client.conn.LRange(ctx, key, 0, -1).Result()
+}
+
+func (client Client) AddHistory(ctx context.Context, key, entry string, ttl time.Duration) error {
+	pipe := client.conn.TxPipeline()
+	pipe.RPush(ctx, key, entry)
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) History(ctx context.Context, key string) ([]string, error) {
+	return client.conn.LRange(ctx, key, 0, -1).Result()
+}
+
+func (client Client) IndexTicket(ctx context.Context, indexKey string, number, date int64) error {
+	_, err := client.conn.ZAdd(ctx, indexKey, redis.Z{Score: float64(date), Member: number}).Result()
+	return err
+}
+
+func (client Client) TicketNumbers(ctx context.Context, indexKey string, from, to int64) ([]string, error) {
+	return client.conn.ZRangeByScore(ctx, indexKey, &redis.ZRangeBy{
+		Min: fmt.Sprint(from),
+		Max: fmt.Sprint(to),
+	}).Result()
+}
+
+// IndexTerms adds the ticket to the term sets scored by the time it was
+// indexed, the sets are kept for ttl after the last update unless it is zero.
+func (client Client) IndexTerms(ctx context.Context, termKeys []string, number int64, now time.Time, ttl time.Duration) error {
+	pipe := client.conn.Pipeline()
+	for _, key := range termKeys {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Unix()), Member: number})
+		if ttl > 0 {
+			pipe.Expire(ctx, key, ttl)
+		}
+	}
+	_, err := pipe.Exec(ctx)
 	return err
 }
+
+// SearchTerms removes the tickets indexed before since, unless it is zero,
+// and returns the tickets found in all the term sets.
+func (client Client) SearchTerms(ctx context.Context, since time.Time, termKeys ...string) ([]string, error) {
+	if !since.IsZero() {
+		pipe := client.conn.Pipeline()
+		for _, key := range termKeys {
+			pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", since.Unix()))
+		}
+		_, err := pipe.Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client.conn.ZInter(ctx, &redis.ZStore{Keys: termKeys}).Result()
+}
+
+func (client Client) NextTicketNumber(ctx context.Context, key string) (int64, error) {
+	return client.conn.Incr(ctx, key).Result()
+}
+func (client Client) Exists(ctx context.Context, key string) (bool, error) {
+	count, err := client.conn.Exists(ctx, key).Result()
+	return count > 0, err
+}
+
 func (client Client) AllTopics(ctx context.Context, key string) ([]string, error) {
 	return client.conn.LRange(ctx, key, 0, -1).Result()
 }
 
+func (client Client) AddDelivery(ctx context.Context, key, delivery string, limit int64) error {
+	pipe := client.conn.TxPipeline()
+	pipe.RPush(ctx, key, delivery)
+	pipe.LTrim(ctx, key, -limit, -1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) Deliveries(ctx context.Context, key string) ([]string, error) {
+	return client.conn.LRange(ctx, key, 0, -1).Result()
+}
+
+func (client Client) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.conn.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	return keys, iter.Err()
+}
+
+func (client Client) DeleteKey(ctx context.Context, key string) error {
+	_, err := client.conn.Del(ctx, key).Result()
+	return err
+}
+
+// MigrateChat applies the moves in one transaction, so the tenant is never
+// split between the old and the new chat.
+func (client Client) MigrateChat(ctx context.Context, moves []entity.KeyMove, topicListKey string) error {
+	pipe := client.conn.TxPipeline()
+	for _, move := range moves {
+		switch {
+		case move.From == "":
+			pipe.Set(ctx, move.To, move.Value, 0)
+		case move.Value == "":
+			pipe.Rename(ctx, move.From, move.To)
+		default:
+			pipe.Set(ctx, move.To, move.Value, 0)
+			pipe.Del(ctx, move.From)
+		}
+
+		if move.Listed {
+			pipe.LRem(ctx, topicListKey, 0, move.From)
+			pipe.LPush(ctx, topicListKey, move.To)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) ChatMigration(ctx context.Context, key string) (int64, error) {
+	res, err := client.conn.Get(ctx, key).Int64()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = 0
+	}
+	return res, err
+}
+
+func (client Client) EnqueueMessage(ctx context.Context, queueKey, queueListKey string, chatID int64, msg string) error {
+	pipe := client.conn.TxPipeline()
+	pipe.RPush(ctx, queueKey, msg)
+	pipe.SAdd(ctx, queueListKey, chatID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) QueuedMessage(ctx context.Context, queueKey string) (string, error) {
+	res, err := client.conn.LIndex(ctx, queueKey, 0).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) DequeueMessage(ctx context.Context, queueKey string) error {
+	_, err := client.conn.LPop(ctx, queueKey).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+	return err
+}
+
+func (client Client) QueueLength(ctx context.Context, queueKey string) (int64, error) {
+	return client.conn.LLen(ctx, queueKey).Result()
+}
+
+func (client Client) QueuedChats(ctx context.Context, queueListKey string) ([]string, error) {
+	return client.conn.SMembers(ctx, queueListKey).Result()
+}
+
+func (client Client) AddQueuedChat(ctx context.Context, queueListKey string, chatID int64) error {
+	_, err := client.conn.SAdd(ctx, queueListKey, chatID).Result()
+	return err
+}
+
+func (client Client) RemoveQueuedChat(ctx context.Context, queueListKey string, chatID int64) error {
+	_, err := client.conn.SRem(ctx, queueListKey, chatID).Result()
+	return err
+}
+
+func (client Client) SetAlert(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return client.conn.SetNX(ctx, key, 1, ttl).Result()
+}
+
+func (client Client) ClearAlert(ctx context.Context, key string) error {
+	_, err := client.conn.Del(ctx, key).Result()
+	return err
+}
+
+func (client Client) Agents(ctx context.Context, key string) (map[string]string, error) {
+	return client.conn.HGetAll(ctx, key).Result()
+}
+
+func (client Client) Agent(ctx context.Context, key, field string) (string, error) {
+	res, err := client.conn.HGet(ctx, key, field).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetAgent(ctx context.Context, key, field, agent string) error {
+	_, err := client.conn.HSet(ctx, key, field, agent).Result()
+	return err
+}
+
+func (client Client) RemoveAgent(ctx context.Context, key, field string) error {
+	_, err := client.conn.HDel(ctx, key, field).Result()
+	return err
+}
+
+func (client Client) AgentLoads(ctx context.Context, key string) (map[string]string, error) {
+	return client.conn.HGetAll(ctx, key).Result()
+}
+
+func (client Client) ChangeAgentLoad(ctx context.Context, key, field string, delta int64) error {
+	_, err := client.conn.HIncrBy(ctx, key, field, delta).Result()
+	return err
+}
+
+func (client Client) NextAgentTurn(ctx context.Context, key string) (int64, error) {
+	return client.conn.Incr(ctx, key).Result()
+}
+
+func (client Client) Shifts(ctx context.Context, key string) (map[string]string, error) {
+	return client.conn.HGetAll(ctx, key).Result()
+}
+
+func (client Client) SetShifts(ctx context.Context, key, field, shifts string) error {
+	_, err := client.conn.HSet(ctx, key, field, shifts).Result()
+	return err
+}
+
+func (client Client) RemoveShifts(ctx context.Context, key, field string) error {
+	_, err := client.conn.HDel(ctx, key, field).Result()
+	return err
+}
+
+func (client Client) DutyStatus(ctx context.Context, key string) (string, error) {
+	res, err := client.conn.Get(ctx, key).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetDutyStatus(ctx context.Context, key, status string, ttl time.Duration) error {
+	_, err := client.conn.Set(ctx, key, status, ttl).Result()
+	return err
+}
+
+func (client Client) UserState(ctx context.Context, key string) (string, error) {
+	res, err := client.conn.Get(ctx, key).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetUserState(ctx context.Context, key, state string, ttl time.Duration) error {
+	_, err := client.conn.Set(ctx, key, state, ttl).Result()
+	return err
+}
+
+// TakeUserState returns the state and removes it, so only one of the
+// concurrent callers gets it.
+func (client Client) TakeUserState(ctx context.Context, key string) (string, error) {
+	res, err := client.conn.GetDel(ctx, key).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+// SwapUserState sets the state and returns the previous one.
+func (client Client) SwapUserState(ctx context.Context, key, state string, ttl time.Duration) (string, error) {
+	res, err := client.conn.SetArgs(ctx, key, state, redis.SetArgs{TTL: ttl, Get: true}).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+// TrackMessage adds the message to the sliding window and returns the
+// number of messages in it.
+func (client Client) TrackMessage(ctx context.Context, key, member string, now time.Time, window time.Duration) (int64, error) {
+	pipe := client.conn.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint(now.Add(-window).UnixMilli()))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// CountMessages returns the number of messages in the sliding window.
+func (client Client) CountMessages(ctx context.Context, key string, now time.Time, window time.Duration) (int64, error) {
+	pipe := client.conn.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint(now.Add(-window).UnixMilli()))
+	count := pipe.ZCard(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// HoldMessage puts the message at the end of the held list and marks the
+// list as one to release.
+func (client Client) HoldMessage(ctx context.Context, key, listKey, member, msg string) error {
+	pipe := client.conn.TxPipeline()
+	pipe.RPush(ctx, key, msg)
+	pipe.SAdd(ctx, listKey, member)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) HeldLists(ctx context.Context, listKey string) ([]string, error) {
+	return client.conn.SMembers(ctx, listKey).Result()
+}
+
+// ReleaseHeldList unmarks the held list unless a message was held in the
+// meantime.
+func (client Client) ReleaseHeldList(ctx context.Context, key, listKey, member string) error {
+	err := client.conn.Watch(ctx, func(tx *redis.Tx) error {
+		held, err := tx.LLen(ctx, key).Result()
+		if err != nil || held > 0 {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SRem(ctx, listKey, member)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return nil
+	}
+	return err
+}
+
+func (client Client) DropHeldMessages(ctx context.Context, key string) error {
+	_, err := client.conn.Del(ctx, key).Result()
+	return err
+}
+
+func (client Client) Rating(ctx context.Context, key string) (string, error) {
+	res, err := client.conn.Get(ctx, key).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetRating(ctx context.Context, key, indexKey, rating string, date int64) error {
+	pipe := client.conn.TxPipeline()
+	pipe.Set(ctx, key, rating, 0)
+	pipe.ZAdd(ctx, indexKey, redis.Z{Score: float64(date), Member: key})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) RatingKeys(ctx context.Context, indexKey string, from, to int64) ([]string, error) {
+	return client.conn.ZRangeByScore(ctx, indexKey, &redis.ZRangeBy{
+		Min: fmt.Sprint(from),
+		Max: fmt.Sprint(to),
+	}).Result()
+}
+
+func (client Client) Bans(ctx context.Context, key string) (map[string]string, error) {
+	return client.conn.HGetAll(ctx, key).Result()
+}
+
+func (client Client) Ban(ctx context.Context, key, userID string) (string, error) {
+	res, err := client.conn.HGet(ctx, key, userID).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetBan(ctx context.Context, key, userID, ban string) error {
+	_, err := client.conn.HSet(ctx, key, userID, ban).Result()
+	return err
+}
+
+func (client Client) RemoveBan(ctx context.Context, key, userID string) error {
+	_, err := client.conn.HDel(ctx, key, userID).Result()
+	return err
+}
+
+func (client Client) Macros(ctx context.Context, key string) (map[string]string, error) {
+	return client.conn.HGetAll(ctx, key).Result()
+}
+
+func (client Client) Macro(ctx context.Context, key, name string) (string, error) {
+	res, err := client.conn.HGet(ctx, key, name).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetMacro(ctx context.Context, key, name, macro string) error {
+	_, err := client.conn.HSet(ctx, key, name, macro).Result()
+	return err
+}
+
+func (client Client) RemoveMacro(ctx context.Context, key, name string) error {
+	_, err := client.conn.HDel(ctx, key, name).Result()
+	return err
+}
+
+func (client Client) Articles(ctx context.Context, key string) (map[string]string, error) {
+	return client.conn.HGetAll(ctx, key).Result()
+}
+
+func (client Client) Article(ctx context.Context, key, id string) (string, error) {
+	res, err := client.conn.HGet(ctx, key, id).Result()
+	if err != nil && err == redis.Nil {
+		err = nil
+		res = ""
+	}
+	return res, err
+}
+
+func (client Client) SetArticle(ctx context.Context, key, id, article string) error {
+	_, err := client.conn.HSet(ctx, key, id, article).Result()
+	return err
+}
+
+func (client Client) RemoveArticle(ctx context.Context, key, id string) error {
+	_, err := client.conn.HDel(ctx, key, id).Result()
+	return err
+}
+
+// IncrementStats counts the fields in the hash and keeps it for ttl after
+// the last update.
+func (client Client) IncrementStats(ctx context.Context, key string, ttl time.Duration, fields ...string) error {
+	pipe := client.conn.TxPipeline()
+	for _, field := range fields {
+		pipe.HIncrBy(ctx, key, field, 1)
+	}
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (client Client) Stats(ctx context.Context, keys ...string) ([]map[string]string, error) {
+	pipe := client.conn.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.HGetAll(ctx, key))
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]map[string]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		stats = append(stats, cmd.Val())
+	}
+	return stats, nil
+}
+
 func connect(host, port, password string) (*redis.Client, error) {
 	options := &redis.Options{
 		Addr: fmt.Sprintf("%s:%s", host, port),
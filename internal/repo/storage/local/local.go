@@ -0,0 +1,41 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+type Storage struct {
+	dir string
+}
+
+func New(dir string) (Storage, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Storage{}, err
+	}
+
+	err = os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return Storage{}, err
+	}
+
+	return Storage{dir}, nil
+}
+
+// Put writes the object under the storage directory and returns its path.
+func (storage Storage) Put(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	path := filepath.Join(storage.dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(path, data, 0o640)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
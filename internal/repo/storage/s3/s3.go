@@ -0,0 +1,157 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	service = "s3"
+	algorithm = "AWS4-HMAC-SHA256"
+	dateLayout = "20060102"
+	timeLayout = "20060102T150405Z"
+)
+
+// Storage puts objects to an S3-compatible API with path-style URLs,
+// so it works with MinIO and local stubs as well as with AWS.
+type Storage struct {
+	endpoint *url.URL
+	bucket string
+	region string
+	accessKey string
+	secretKey string
+	client *http.Client
+}
+
+func New(endpoint, bucket, region, accessKey, secretKey string) (Storage, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return Storage{}, err
+	}
+
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return Storage{}, fmt.Errorf("invalid S3 endpoint %s", endpoint)
+	}
+
+	if bucket == "" {
+		return Storage{}, fmt.Errorf("the S3 bucket is not set")
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return Storage{
+		endpoint: parsed,
+		bucket: bucket,
+		region: region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Put uploads the object and returns its URL.
+func (storage Storage) Put(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	objectURL := *storage.endpoint
+	objectURL.Path = fmt.Sprintf("%s/%s/%s", storage.endpoint.Path, storage.bucket, strings.TrimPrefix(name, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	storage.sign(req, data, time.Now().UTC())
+
+	resp, err := storage.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("S3 put %s: %s %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return objectURL.String(), nil
+}
+
+// sign adds the AWS Signature Version 4 headers to the request. The host,
+// the content type, the range and every x-amz header are signed.
+func (storage Storage) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := hashHex(payload)
+	req.Header.Set("X-Amz-Date", now.Format(timeLayout))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	var canonicalHeaders strings.Builder
+	for _, header := range headers {
+		value := req.Header.Get(header)
+		if header == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", header, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(dateLayout), storage.region, service)
+	stringToSign := strings.Join([]string{
+		algorithm,
+		now.Format(timeLayout),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+storage.secretKey), now.Format(dateLayout))
+	key = hmacSHA256(key, storage.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		algorithm,
+		storage.accessKey,
+		scope,
+		signedHeaders,
+		signature))
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"io"
 	"log/slog"
 	"time"
 	"gopkg.in/telebot.v3"
@@ -44,6 +45,10 @@ func NewWithoutDecryption(log *slog.Logger, token string, timeout int) (*Bot, er
 	}, nil
 }
 
+func (bot *Bot) File(fileID string) (io.ReadCloser, error) {
+	return bot.client.File(&telebot.File{FileID: fileID})
+}
+
 func (bot *Bot) Token() string {
 	return bot.token
 }
@@ -60,10 +65,28 @@ func (bot *Bot) Send(to telebot.Recipient, what string, opts *telebot.SendOption
 	return bot.client.Send(to, what, opts)
 }
 
+func (bot *Bot) Pin(msg telebot.Editable, opts ...interface{}) error {
+	return bot.client.Pin(msg, opts...)
+}
+
+func (bot *Bot) Respond(callbackID, text string) error {
+	return bot.client.Respond(
+		&telebot.Callback{ID: callbackID},
+		&telebot.CallbackResponse{Text: text})
+}
+
 func (bot *Bot) CreateTopic(chat *telebot.Chat, topic *telebot.Topic) (*telebot.Topic, error) {
 	return bot.client.CreateTopic(chat, topic)
 }
 
+func (bot *Bot) EditTopic(chat *telebot.Chat, topic *telebot.Topic) error {
+	return bot.client.EditTopic(chat, topic)
+}
+
+func (bot *Bot) ReopenTopic(chat *telebot.Chat, topic *telebot.Topic) error {
+	return bot.client.ReopenTopic(chat, topic)
+}
+
 func (bot *Bot) CloseTopic(chat *telebot.Chat, topic *telebot.Topic) error {
 	return bot.client.CloseTopic(chat, topic)
 }
@@ -76,8 +99,12 @@ func (bot *Bot) Close() {
 	bot.client.Close()
 }
 
-func (bot *Bot) EditMessage(msg *telebot.Message, what string) (*telebot.Message, error) {
-	return bot.client.Edit(msg, what)
+func (bot *Bot) EditMessage(msg *telebot.Message, what string, opts ...interface{}) (*telebot.Message, error) {
+	return bot.client.Edit(msg, what, opts...)
+}
+
+func (bot *Bot) EditReplyMarkup(msg *telebot.Message, markup *telebot.ReplyMarkup) (*telebot.Message, error) {
+	return bot.client.EditReplyMarkup(msg, markup)
 }
 
 func newBotClient(token string, timeout int) (*telebot.Bot, error) {
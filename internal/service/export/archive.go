@@ -0,0 +1,117 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+const archiveVersion = 1
+
+// Metadata describes the ticket and the topic at the moment of archiving.
+type Metadata struct {
+	Ticket entity.Ticket
+	Profile entity.UserProfile
+	TopicID int
+	Reason string
+	ArchivedAt int64
+}
+
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+type Manifest struct {
+	Version int
+	Ticket int64
+	GroupChatID int64
+	CreatedAt int64
+	Files []ManifestFile
+}
+
+type ManifestFile struct {
+	Name string
+	Size int
+	SHA256 string
+}
+
+// Archive writes a tar.gz with the transcript as JSON and HTML, the
+// metadata and the manifest listing the checksums of the other files.
+func Archive(w io.Writer, transcript entity.Transcript, metadata Metadata) error {
+	var jsonTranscript, htmlTranscript bytes.Buffer
+	err := Render(&jsonTranscript, FormatJSON, []entity.Transcript{transcript})
+	if err != nil {
+		return err
+	}
+
+	err = Render(&htmlTranscript, FormatHTML, []entity.Transcript{transcript})
+	if err != nil {
+		return err
+	}
+
+	jsonMetadata, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	files := []archiveFile{
+		{"transcript.json", jsonTranscript.Bytes()},
+		{"transcript.html", htmlTranscript.Bytes()},
+		{"metadata.json", jsonMetadata},
+	}
+
+	manifest := Manifest{
+		Version: archiveVersion,
+		Ticket: transcript.Ticket.Number,
+		GroupChatID: transcript.Ticket.GroupChatID,
+		CreatedAt: metadata.ArchivedAt,
+	}
+	for _, file := range files {
+		sum := sha256.Sum256(file.data)
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name: file.name,
+			Size: len(file.data),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	jsonManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	modTime := time.Unix(metadata.ArchivedAt, 0)
+	for _, file := range append(files, archiveFile{"manifest.json", jsonManifest}) {
+		err = archive.WriteHeader(&tar.Header{
+			Name: file.name,
+			Mode: 0o640,
+			Size: int64(len(file.data)),
+			ModTime: modTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = archive.Write(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+
+	return compressed.Close()
+}
@@ -0,0 +1,109 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	transcript := entity.Transcript{
+		Ticket: entity.Ticket{Number: 7, GroupChatID: -100, Status: entity.TicketClosed},
+		Entries: []entity.HistoryEntry{
+			{Kind: entity.HistoryUser, Ticket: 7, Type: entity.MessageText, Text: "Hello", Date: 1700000000},
+			{Kind: entity.HistoryAgent, Ticket: 7, Author: "Anna", Type: entity.MessageText, Text: "Hi <there>", Date: 1700000060},
+		},
+	}
+	metadata := Metadata{Ticket: transcript.Ticket, TopicID: 42, Reason: "closed", ArchivedAt: 1700000100}
+
+	var data bytes.Buffer
+	err := Archive(&data, transcript, metadata)
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+
+	files := readArchive(t, &data)
+	for _, name := range []string{"transcript.json", "transcript.html", "metadata.json", "manifest.json"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("%s is missing from the archive", name)
+		}
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(files["manifest.json"], &manifest)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+
+	if manifest.Ticket != 7 || manifest.GroupChatID != -100 || manifest.CreatedAt != 1700000100 {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	if len(manifest.Files) != 3 {
+		t.Fatalf("manifest lists %d files, want 3", len(manifest.Files))
+	}
+
+	for _, file := range manifest.Files {
+		sum := sha256.Sum256(files[file.Name])
+		if hex.EncodeToString(sum[:]) != file.SHA256 || len(files[file.Name]) != file.Size {
+			t.Errorf("%s doesn't match its manifest checksum", file.Name)
+		}
+	}
+
+	var transcripts []entity.Transcript
+	err = json.Unmarshal(files["transcript.json"], &transcripts)
+	if err != nil {
+		t.Fatalf("read transcript: %v", err)
+	}
+
+	if len(transcripts) != 1 || transcripts[0].Ticket.Number != 7 || len(transcripts[0].Entries) != 2 {
+		t.Fatalf("unexpected transcript %+v", transcripts)
+	}
+
+	if transcripts[0].Entries[1].Text != "Hi <there>" {
+		t.Errorf("entry text %q, want %q", transcripts[0].Entries[1].Text, "Hi <there>")
+	}
+
+	var archived Metadata
+	err = json.Unmarshal(files["metadata.json"], &archived)
+	if err != nil {
+		t.Fatalf("read metadata: %v", err)
+	}
+
+	if archived.TopicID != 42 || archived.Reason != "closed" {
+		t.Errorf("unexpected metadata %+v", archived)
+	}
+}
+
+func readArchive(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+
+	files := make(map[string][]byte)
+	archive := tar.NewReader(compressed)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			t.Fatalf("read %s: %v", header.Name, err)
+		}
+		files[header.Name] = data
+	}
+}
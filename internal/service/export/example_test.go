@@ -0,0 +1,26 @@
+package export_test
+
+import (
+	"os"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/export"
+)
+
+func ExampleRender() {
+	transcripts := []entity.Transcript{{
+		Ticket: entity.Ticket{Number: 7},
+		Entries: []entity.HistoryEntry{
+			{Kind: entity.HistoryUser, Ticket: 7, Type: entity.MessageText, Text: "Hello, \"support\"", Date: 1700000000},
+			{Kind: entity.HistoryAgent, Ticket: 7, Author: "Anna", Type: entity.MessageText, Text: "Hi", Date: 1700000060},
+			{Kind: entity.HistoryUser, Ticket: 7, Type: "photo", FileIDs: []string{"file-1", "file-2"}, Date: 1700000120},
+		},
+	}}
+
+	export.Render(os.Stdout, export.FormatCSV, transcripts)
+	// Output:
+	// ticket,date,sender,author,type,text,files
+	// 7,2023-11-14 22:13:20 UTC,user,,text,"Hello, ""support""",
+	// 7,2023-11-14 22:14:20 UTC,agent,Anna,text,Hi,
+	// 7,2023-11-14 22:15:20 UTC,user,,photo,,file-1 file-2
+}
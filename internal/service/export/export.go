@@ -0,0 +1,223 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV = "csv"
+	FormatHTML = "html"
+	attachmentsDir = "attachments/"
+	timeLayout = "2006-01-02 15:04:05"
+	dateLayout = "2006-01-02"
+)
+
+// FetchFunc downloads the attachment by its Telegram file ID.
+type FetchFunc func(fileID string) (io.ReadCloser, error)
+
+var csvHeader = []string{"ticket", "date", "sender", "author", "type", "text", "files"}
+
+var page = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"date": formatDate,
+	"file": func(bundle bool, id string) string {
+		if bundle {
+			return attachmentsDir + id
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Support transcripts</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: .3em; }
+.meta { color: #666; font-size: .9em; }
+.entry { margin: .6em 0; padding: .5em .8em; border-radius: 6px; }
+.user { background: #eef4ff; }
+.agent { background: #eefbee; margin-left: 3em; }
+.note { background: #fff7dd; margin-left: 3em; font-style: italic; }
+.sender { font-weight: bold; }
+.text { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+{{range .Transcripts}}
+<h2>Ticket #{{.Ticket.Number}}</h2>
+<p class="meta">Status: {{.Ticket.Status}}, priority: {{.Ticket.Priority}}, created: {{date .Ticket.CreatedAt}}{{if .Ticket.Assignee}}, assignee: {{.Ticket.Assignee}}{{end}}</p>
+{{range .Entries}}
+<div class="entry {{.Kind}}">
+<div><span class="sender">{{.Kind}}{{if .Author}} · {{.Author}}{{end}}</span> <span class="meta">{{date .Date}}{{if ne .Type "text"}} · {{.Type}}{{end}}</span></div>
+{{if .Text}}<div class="text">{{.Text}}</div>{{end}}
+{{range .FileIDs}}<div class="meta">📎 {{with file $.Bundle .}}<a href="{{.}}">{{.}}</a>{{else}}{{.}}{{end}}</div>{{end}}
+</div>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// ParsePeriod reads the optional from and to dates like 2026-01-31,
+// the to date is inclusive.
+func ParsePeriod(fromDate, toDate string) (time.Time, time.Time, error) {
+	from := time.Unix(0, 0)
+	to := time.Now()
+
+	if fromDate != "" {
+		date, err := time.Parse(dateLayout, fromDate)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date")
+		}
+		from = date
+	}
+
+	if toDate != "" {
+		date, err := time.Parse(dateLayout, toDate)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date")
+		}
+		to = date.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	return from, to, nil
+}
+
+func IsFormat(format string) bool {
+	return format == FormatJSON || format == FormatCSV || format == FormatHTML
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// Render writes the transcripts in the format, attachments are referenced
+// by their file IDs.
+func Render(w io.Writer, format string, transcripts []entity.Transcript) error {
+	return render(w, format, transcripts, false)
+}
+
+// Bundle writes a zip archive with the rendered transcripts and the
+// downloaded attachments. An attachment that can't be downloaded is
+// left as the file ID reference.
+func Bundle(w io.Writer, format string, transcripts []entity.Transcript, fetch FetchFunc) error {
+	archive := zip.NewWriter(w)
+
+	file, err := archive.Create("transcript." + format)
+	if err != nil {
+		return err
+	}
+
+	err = render(file, format, transcripts, true)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, transcript := range transcripts {
+		for _, id := range transcript.FileIDs() {
+			err = addAttachment(archive, id, fetch)
+			if err != nil {
+				missing = append(missing, fmt.Sprintf("%s: %s", id, err))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		file, err = archive.Create("missing.txt")
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(file, strings.Join(missing, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func addAttachment(archive *zip.Writer, id string, fetch FetchFunc) error {
+	data, err := fetch(id)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	file, err := archive.Create(attachmentsDir + id)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, data)
+	return err
+}
+
+func render(w io.Writer, format string, transcripts []entity.Transcript, bundle bool) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(transcripts)
+	case FormatCSV:
+		return renderCSV(w, transcripts)
+	case FormatHTML:
+		return page.Execute(w, struct {
+			Transcripts []entity.Transcript
+			Bundle bool
+		}{transcripts, bundle})
+	default:
+		return fmt.Errorf("unknown export format %s, use json, csv or html", format)
+	}
+}
+
+func renderCSV(w io.Writer, transcripts []entity.Transcript) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, transcript := range transcripts {
+		for _, entry := range transcript.Entries {
+			err = writer.Write([]string{
+				strconv.FormatInt(entry.Ticket, 10),
+				formatDate(entry.Date),
+				entry.Kind,
+				entry.Author,
+				entry.Type,
+				entry.Text,
+				strings.Join(entry.FileIDs, " "),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatDate(date int64) string {
+	return time.Unix(date, 0).UTC().Format(timeLayout) + " UTC"
+}
@@ -0,0 +1,62 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+var testTranscripts = []entity.Transcript{{
+	Ticket: entity.Ticket{Number: 7, Status: entity.TicketClosed, Priority: "high", CreatedAt: 1700000000},
+	Entries: []entity.HistoryEntry{
+		{Kind: entity.HistoryAgent, Ticket: 7, Author: "Anna", Type: entity.MessageText, Text: "<b>Hi</b>", Date: 1700000060},
+		{Kind: entity.HistoryUser, Ticket: 7, Type: "photo", FileIDs: []string{"file-1"}, Date: 1700000120},
+	},
+}}
+
+func TestRenderJSON(t *testing.T) {
+	var output bytes.Buffer
+	err := Render(&output, FormatJSON, testTranscripts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []entity.Transcript
+	err = json.Unmarshal(output.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, testTranscripts) {
+		t.Errorf("decoded %+v, want %+v", decoded, testTranscripts)
+	}
+}
+
+func TestRenderHTMLEscapesMessages(t *testing.T) {
+	var output bytes.Buffer
+	err := Render(&output, FormatHTML, testTranscripts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := output.String()
+	if strings.Contains(html, "<b>Hi</b>") || !strings.Contains(html, "&lt;b&gt;Hi&lt;/b&gt;") {
+		t.Error("the message text is not escaped")
+	}
+
+	// Without the bundle the attachments aren't downloaded, so there is
+	// nothing to link to.
+	if strings.Contains(html, "href=") || !strings.Contains(html, "📎 file-1") {
+		t.Error("the attachment is linked or missing")
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	var output bytes.Buffer
+	if err := Render(&output, "xml", testTranscripts); err == nil {
+		t.Error("xml is rendered")
+	}
+}
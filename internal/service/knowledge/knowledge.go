@@ -0,0 +1,189 @@
+package knowledge
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	stemLength = 6
+	keywordWeight = 0.4
+)
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "you": true, "your": true, "are": true,
+	"is": true, "to": true, "of": true, "in": true, "on": true, "it": true,
+	"my": true, "me": true, "do": true, "how": true, "can": true, "what": true,
+	"и": true, "в": true, "на": true, "не": true, "что": true, "как": true,
+	"мне": true, "мой": true, "моя": true, "у": true, "с": true, "по": true,
+}
+
+// Document is a knowledge base entry, the keywords add to its score when
+// all of their words are in the query.
+type Document struct {
+	ID string
+	Text string
+	Keywords []string
+}
+
+type Match struct {
+	ID string
+	Score float64
+}
+
+// Index matches the queries with the documents by the TF-IDF cosine
+// similarity of their words.
+type Index struct {
+	docs []indexedDocument
+	idf map[string]float64
+}
+
+type indexedDocument struct {
+	id string
+	vector map[string]float64
+	keywords [][]string
+}
+
+func NewIndex(docs []Document) *Index {
+	frequency := make(map[string]int)
+	terms := make([][]string, len(docs))
+	for i, doc := range docs {
+		terms[i] = Terms(doc.Text + " " + strings.Join(doc.Keywords, " "))
+		for term := range counts(terms[i]) {
+			frequency[term]++
+		}
+	}
+
+	index := &Index{
+		docs: make([]indexedDocument, 0, len(docs)),
+		idf: make(map[string]float64, len(frequency)),
+	}
+	for term, count := range frequency {
+		index.idf[term] = math.Log(float64(len(docs)+1)/float64(count+1)) + 1
+	}
+
+	for i, doc := range docs {
+		keywords := make([][]string, 0, len(doc.Keywords))
+		for _, keyword := range doc.Keywords {
+			if words := Terms(keyword); len(words) > 0 {
+				keywords = append(keywords, words)
+			}
+		}
+
+		index.docs = append(index.docs, indexedDocument{
+			id: doc.ID,
+			vector: index.vector(terms[i]),
+			keywords: keywords,
+		})
+	}
+
+	return index
+}
+
+// Match returns up to limit documents scored at least minScore, best first.
+func (index *Index) Match(query string, limit int, minScore float64) []Match {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	queryTerms := counts(terms)
+	queryVector := index.vector(terms)
+
+	var matches []Match
+	for _, doc := range index.docs {
+		score := cosine(queryVector, doc.vector)
+		if len(doc.keywords) > 0 {
+			found := 0
+			for _, keyword := range doc.keywords {
+				if containsAll(queryTerms, keyword) {
+					found++
+				}
+			}
+			score = (1-keywordWeight)*score + keywordWeight*float64(found)/float64(len(doc.keywords))
+		}
+
+		if score >= minScore && score > 0 {
+			matches = append(matches, Match{ID: doc.id, Score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches
+}
+
+// Terms splits the text into lower-cased word stems without stop words.
+func Terms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+
+		runes := []rune(word)
+		if len(runes) < 2 {
+			continue
+		}
+		if len(runes) > stemLength {
+			runes = runes[:stemLength]
+		}
+		terms = append(terms, string(runes))
+	}
+
+	return terms
+}
+
+func (index *Index) vector(terms []string) map[string]float64 {
+	vector := make(map[string]float64, len(terms))
+	for term, count := range counts(terms) {
+		vector[term] = float64(count) * index.idf[term]
+	}
+	return vector
+}
+
+func cosine(a, b map[string]float64) float64 {
+	var dot, normA, normB float64
+	for term, weight := range a {
+		dot += weight * b[term]
+		normA += weight * weight
+	}
+	for _, weight := range b {
+		normB += weight * weight
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func counts(terms []string) map[string]int {
+	result := make(map[string]int, len(terms))
+	for _, term := range terms {
+		result[term]++
+	}
+	return result
+}
+
+func containsAll(terms map[string]int, words []string) bool {
+	for _, word := range words {
+		if terms[word] == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package knowledge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestIndex() *Index {
+	return NewIndex([]Document{
+		{ID: "refund", Text: "How do I get a refund for my order?", Keywords: []string{"money back"}},
+		{ID: "delivery", Text: "When will my order be delivered?", Keywords: []string{"shipping"}},
+		{ID: "password", Text: "How can I reset the password of my account?"},
+		{ID: "ru-refund", Text: "Как вернуть деньги за заказ?"},
+	})
+}
+
+func matchIDs(matches []Match) []string {
+	var ids []string
+	for _, match := range matches {
+		ids = append(ids, match.ID)
+	}
+	return ids
+}
+
+func TestIndexMatch(t *testing.T) {
+	index := newTestIndex()
+
+	t.Run("the question finds its article", func(t *testing.T) {
+		for _, query := range []string{"How do I get a refund for my order?", "refunding the ordered items", "I want my money back"} {
+			if got := matchIDs(index.Match(query, 1, 0.1)); !reflect.DeepEqual(got, []string{"refund"}) {
+				t.Errorf("Match(%q) = %q, want the refund article", query, got)
+			}
+		}
+
+		if got := matchIDs(index.Match("вернуть деньги", 3, 0.3)); !reflect.DeepEqual(got, []string{"ru-refund"}) {
+			t.Errorf("cyrillic query found %q", got)
+		}
+	})
+
+	t.Run("a shared word ranks the shorter article first", func(t *testing.T) {
+		if got := matchIDs(index.Match("order", 3, 0.1)); !reflect.DeepEqual(got, []string{"refund", "delivery"}) {
+			t.Errorf("Match(order) = %q", got)
+		}
+
+		if got := matchIDs(index.Match("order", 1, 0.1)); len(got) != 1 {
+			t.Errorf("the limit of 1 returned %q", got)
+		}
+
+		if got := index.Match("order", 3, 0.9); len(got) != 0 {
+			t.Errorf("weak matches pass the min score: %q", matchIDs(got))
+		}
+	})
+
+	t.Run("nothing to match", func(t *testing.T) {
+		for _, query := range []string{"how can you do it", "weather forecast"} {
+			if got := index.Match(query, 3, 0); len(got) != 0 {
+				t.Errorf("Match(%q) = %q, want nothing", query, matchIDs(got))
+			}
+		}
+	})
+}
+
+func TestTerms(t *testing.T) {
+	tests := map[string][]string{
+		"How do I reset my password?": {"reset", "passwo"},
+		"Order #1234": {"order", "1234"},
+		"Как вернуть деньги": {"вернут", "деньги"},
+		"a b": {},
+	}
+
+	for text, want := range tests {
+		if got := Terms(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Terms(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package supportline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/internal/service/export"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+)
+
+const (
+	// The archive is encrypted with the CRYPTO_KEY, decrypt it with
+	// crypto.DecryptBytes to get the tar.gz.
+	archiveName = "%d/ticket-%d-%d.tar.gz.enc"
+	encryptedContentType = "application/octet-stream"
+	archivePurged = "purge"
+	archiveClosed = "closed"
+)
+
+// archiveTopic writes the ticket transcript, metadata and manifest to the
+// archive storage and posts the summary to the tenant archive topic.
+func (support *Support) archiveTopic(topicData entity.TopicData, reason string, bot *bot.Bot) error {
+	if support.storage == nil {
+		return nil
+	}
+
+	ticket, err := support.topicTicket(topicData)
+	if err != nil {
+		return err
+	}
+
+	if reason == archivePurged && ticket.Status == entity.TicketClosed && ticket.ArchivedAt != 0 {
+		return nil
+	}
+
+	entries, err := support.History(ticket.GroupChatID, ticket.Number)
+	if err != nil {
+		return err
+	}
+
+	profile, _, err := support.profile(ticket.GroupChatID, ticket.UserID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	var data bytes.Buffer
+	err = export.Archive(
+		&data,
+		entity.Transcript{Ticket: ticket.WithoutToken(), Entries: entries},
+		export.Metadata{
+			Ticket: ticket.WithoutToken(),
+			Profile: profile,
+			TopicID: topicData.TopicID,
+			Reason: reason,
+			ArchivedAt: now,
+		})
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := crypto.EncryptBytes(data.Bytes())
+	if err != nil {
+		return err
+	}
+
+	location, err := support.storage.Put(
+		context.Background(),
+		fmt.Sprintf(archiveName, ticket.GroupChatID, ticket.Number, now),
+		encryptedContentType,
+		encrypted)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = support.updateTicket(ticket.GroupChatID, ticket.Number, func(ticket *entity.Ticket) error {
+		ticket.ArchivedAt = now
+		ticket.Archive = location
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if topicID := support.settings.Tenant(ticket.GroupChatID).ArchiveTopicID; topicID != 0 {
+		support.notifyTopic(
+			ticket.GroupChatID,
+			topicID,
+			fmt.Sprintf("🗄 Ticket #%d archived (%s), %d messages: %s", ticket.Number, reason, len(entries), location),
+			bot)
+	}
+
+	return nil
+}
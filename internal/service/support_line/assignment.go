@@ -0,0 +1,374 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	agentsKey = "chatid{%d}:agents"
+	agentLoadKey = "chatid{%d}:agents:load"
+	agentTurnKey = "chatid{%d}:agents:turn"
+	assignManual = "manual"
+	assignRoundRobin = "round-robin"
+	assignLeastLoaded = "least-loaded"
+)
+
+func (support *Support) roster(chatID int64) ([]entity.Agent, error) {
+	records, err := support.db.Agents(context.Background(), fmt.Sprintf(agentsKey, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make([]entity.Agent, 0, len(records))
+	for _, record := range records {
+		agent, err := entity.NewAgentFromJSON([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Key() < agents[j].Key()
+	})
+
+	return agents, nil
+}
+
+func (support *Support) rosterAgent(chatID int64, key string) (entity.Agent, bool, error) {
+	record, err := support.db.Agent(
+		context.Background(),
+		fmt.Sprintf(agentsKey, chatID),
+		strings.ToLower(strings.TrimPrefix(key, "@")))
+	if err != nil || record == "" {
+		return entity.Agent{}, false, err
+	}
+
+	agent, err := entity.NewAgentFromJSON([]byte(record))
+	return agent, err == nil, err
+}
+
+func (support *Support) saveAgent(chatID int64, agent entity.Agent) error {
+	data, err := encoding.ToJSON(agent)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetAgent(
+		context.Background(),
+		fmt.Sprintf(agentsKey, chatID),
+		agent.Key(),
+		string(data))
+}
+
+func (support *Support) removeAgent(chatID int64, key string) error {
+	return support.db.RemoveAgent(
+		context.Background(),
+		fmt.Sprintf(agentsKey, chatID),
+		strings.ToLower(strings.TrimPrefix(key, "@")))
+}
+
+func (support *Support) changeAgentLoad(chatID int64, agentKey string, delta int64) {
+	if agentKey == "" {
+		return
+	}
+
+	err := support.db.ChangeAgentLoad(
+		context.Background(),
+		fmt.Sprintf(agentLoadKey, chatID),
+		agentKey,
+		delta)
+	if err != nil {
+		support.log.Error("Can`t change agent load", "Error", err)
+	}
+}
+
+// assign hands the ticket over to the agent, an empty key unassigns it.
+func (support *Support) assign(ticket *entity.Ticket, agentKey string) error {
+	var previous string
+	updated, found, err := support.updateTicket(ticket.GroupChatID, ticket.Number, func(ticket *entity.Ticket) error {
+		previous = ticket.Assignee
+		if ticket.Assignee == agentKey {
+			return errTicketUnchanged
+		}
+
+		ticket.Assignee = agentKey
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("ticket #%d not found", ticket.Number)
+	}
+
+	if previous != agentKey && updated.Active() {
+		support.changeAgentLoad(updated.GroupChatID, previous, -1)
+		support.changeAgentLoad(updated.GroupChatID, agentKey, 1)
+	}
+	*ticket = updated
+
+	return nil
+}
+
+// pickAgent chooses the on-duty agent for a new ticket according to the
+// tenant assignment mode.
+func (support *Support) pickAgent(chatID int64) (entity.Agent, bool, error) {
+	mode := support.settings.Tenant(chatID).Assignment
+	if mode == "" || mode == assignManual {
+		return entity.Agent{}, false, nil
+	}
+
+	agents, err := support.onDutyAgents(chatID)
+	if err != nil || len(agents) == 0 {
+		return entity.Agent{}, false, err
+	}
+
+	switch mode {
+	case assignRoundRobin:
+		turn, err := support.db.NextAgentTurn(context.Background(), fmt.Sprintf(agentTurnKey, chatID))
+		if err != nil {
+			return entity.Agent{}, false, err
+		}
+		return agents[(turn-1)%int64(len(agents))], true, nil
+	case assignLeastLoaded:
+		loads, err := support.agentLoads(chatID)
+		if err != nil {
+			return entity.Agent{}, false, err
+		}
+
+		best := agents[0]
+		for _, agent := range agents[1:] {
+			if loads[agent.Key()] < loads[best.Key()] {
+				best = agent
+			}
+		}
+		return best, true, nil
+	default:
+		return entity.Agent{}, false, fmt.Errorf("unknown assignment mode %s", mode)
+	}
+}
+
+func (support *Support) agentLoads(chatID int64) (map[string]int64, error) {
+	records, err := support.db.AgentLoads(context.Background(), fmt.Sprintf(agentLoadKey, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	loads := make(map[string]int64, len(records))
+	for key, record := range records {
+		load, err := strconv.ParseInt(record, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		loads[key] = load
+	}
+
+	return loads, nil
+}
+
+func (support *Support) assigneeName(ticket entity.Ticket) string {
+	if ticket.Assignee == "" {
+		return ""
+	}
+
+	agent, found, err := support.rosterAgent(ticket.GroupChatID, ticket.Assignee)
+	if err != nil || !found {
+		return ticket.Assignee
+	}
+
+	return agent.DisplayName()
+}
+
+// mentionAssignee pings the assigned agent in the topic.
+func (support *Support) mentionAssignee(ticket entity.Ticket, text string, bot *bot.Bot) {
+	if ticket.Assignee == "" {
+		return
+	}
+
+	agent, found, err := support.rosterAgent(ticket.GroupChatID, ticket.Assignee)
+	if err != nil {
+		support.log.Error("Can`t load assigned agent", "Error", err)
+		return
+	}
+
+	if !found {
+		agent = entity.NewAgent(0, ticket.Assignee, "")
+	}
+
+	_, err = bot.Send(
+		telebot.ChatID(ticket.GroupChatID),
+		fmt.Sprintf("%s %s", agentMention(agent), html.EscapeString(text)),
+		&telebot.SendOptions{
+			ThreadID: ticket.TopicID,
+			ParseMode: telebot.ModeHTML,
+		})
+	if err != nil {
+		support.log.Error("Can`t mention assigned agent", "Error", err)
+	}
+}
+
+func agentMention(agent entity.Agent) string {
+	if agent.UserName != "" {
+		return "@" + html.EscapeString(agent.UserName)
+	}
+
+	return fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, agent.ID, html.EscapeString(agent.DisplayName()))
+}
+
+func assignCommand(support *Support, cmd command) error {
+	name, _, _ := strings.Cut(cmd.args, " ")
+	if name == "" {
+		return fmt.Errorf("usage: /assign @agent")
+	}
+
+	agent, found, err := support.rosterAgent(cmd.msg.ChatID, name)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("%s is not in the roster, add them with /agent add %s", name, name)
+	}
+
+	return support.assignTopic(cmd, agent)
+}
+
+func takeCommand(support *Support, cmd command) error {
+	sender := cmd.msg.Sender()
+	if sender.ID == 0 && sender.UserName == "" {
+		return fmt.Errorf("the sender of the command is unknown")
+	}
+
+	agent, found, err := support.rosterAgent(cmd.msg.ChatID, sender.Key())
+	if err != nil {
+		return err
+	}
+
+	if found {
+		agent.ID = sender.ID
+		if agent.Name == "" {
+			agent.Name = sender.Name
+		}
+	} else {
+		agent = sender
+	}
+
+	err = support.saveAgent(cmd.msg.ChatID, agent)
+	if err != nil {
+		return err
+	}
+
+	return support.assignTopic(cmd, agent)
+}
+
+func unassignCommand(support *Support, cmd command) error {
+	ticket, err := support.topicTicket(cmd.topic)
+	if err != nil {
+		return err
+	}
+
+	err = support.assign(&ticket, "")
+	if err != nil {
+		return err
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, fmt.Sprintf("Ticket #%d is unassigned", ticket.Number), cmd.bot)
+	return support.refreshCard(cmd.topic, cmd.bot)
+}
+
+func (support *Support) assignTopic(cmd command, agent entity.Agent) error {
+	ticket, err := support.topicTicket(cmd.topic)
+	if err != nil {
+		return err
+	}
+
+	err = support.assign(&ticket, agent.Key())
+	if err != nil {
+		return err
+	}
+
+	support.mentionAssignee(ticket, fmt.Sprintf("ticket #%d is assigned to you", ticket.Number), cmd.bot)
+	return support.refreshCard(cmd.topic, cmd.bot)
+}
+
+func agentCommand(support *Support, cmd command) error {
+	action, args, _ := strings.Cut(cmd.args, " ")
+	userName, name, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if !strings.HasPrefix(userName, "@") {
+		return fmt.Errorf("usage: /agent add @username [name], /agent persona @username [alias] or /agent remove @username")
+	}
+
+	var err error
+	switch action {
+	case "add":
+		agent := entity.NewAgent(0, userName, strings.TrimSpace(name))
+		existing, found, lookupErr := support.rosterAgent(cmd.msg.ChatID, agent.Key())
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if found {
+			agent.ID = existing.ID
+			agent.Persona = existing.Persona
+		}
+		err = support.saveAgent(cmd.msg.ChatID, agent)
+	case "persona":
+		err = support.setPersona(cmd.msg.ChatID, userName, name)
+	case "remove":
+		err = support.removeAgent(cmd.msg.ChatID, userName)
+	default:
+		return fmt.Errorf("unknown action %s, use add, persona or remove", action)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "Roster updated", cmd.bot)
+	return nil
+}
+
+func agentsCommand(support *Support, cmd command) error {
+	agents, err := support.roster(cmd.msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	if len(agents) == 0 {
+		support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "The roster is empty", cmd.bot)
+		return nil
+	}
+
+	loads, err := support.agentLoads(cmd.msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	text.WriteString("Agents:")
+	for _, agent := range agents {
+		fmt.Fprintf(&text, "\n%s", agent.DisplayName())
+		if agent.UserName != "" && agent.Name != "" {
+			fmt.Fprintf(&text, " (@%s)", agent.UserName)
+		}
+		if agent.Persona != "" {
+			fmt.Fprintf(&text, ", persona: %s", agent.Persona)
+		}
+		fmt.Fprintf(&text, ", open tickets: %d", loads[agent.Key()])
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text.String(), cmd.bot)
+	return nil
+}
@@ -0,0 +1,112 @@
+package supportline
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+const defaultLanguage = "default"
+
+type autoReplyData struct {
+	Ticket int64
+	FirstName string
+	Minutes int
+}
+
+func (support *Support) greet(ticket entity.Ticket, profile entity.UserProfile, bot *bot.Bot) {
+	greeting := localized(support.settings.Tenant(ticket.GroupChatID).AutoReply.Greeting, profile.LanguageCode)
+	if greeting == "" {
+		return
+	}
+
+	support.sendAutoReply(ticket, greeting, autoReplyData{Ticket: ticket.Number, FirstName: profile.FirstName}, bot)
+}
+
+func (support *Support) sendAutoReply(ticket entity.Ticket, text string, data autoReplyData, bot *bot.Bot) {
+	reply, err := renderTemplate(text, data)
+	if err != nil {
+		support.log.Error("Can`t render auto reply", "Error", err)
+		return
+	}
+
+	_, err = bot.Send(telebot.ChatID(ticket.ChatID), reply, &telebot.SendOptions{})
+	if err != nil {
+		support.log.Error("Can`t send auto reply", "Error", err)
+	}
+}
+
+func (support *Support) remindWaitingUsersFunc() func() {
+	return func() {
+		tickets, err := support.topicTickets()
+		if err != nil {
+			support.log.Error("Failed to get tickets for reminders", "Error", err)
+			return
+		}
+
+		pool := support.newBotPool()
+		defer pool.close()
+
+		for _, item := range tickets {
+			ticket := item.ticket
+			autoReply := support.settings.Tenant(ticket.GroupChatID).AutoReply
+			if autoReply.ReminderMinutes <= 0 || ticket.Reminded || ticket.FirstResponseAt != 0 || !ticket.Active() {
+				continue
+			}
+
+			waiting := time.Since(time.Unix(ticket.CreatedAt, 0))
+			if waiting < time.Duration(autoReply.ReminderMinutes)*time.Minute {
+				continue
+			}
+
+			client, err := pool.get(item.topic.BotToken)
+			if err != nil {
+				support.log.Error("Can`t initialize bot for reminders", "Error", err)
+				continue
+			}
+
+			profile, _, err := support.profile(ticket.GroupChatID, ticket.UserID)
+			if err != nil {
+				support.log.Error("Can`t load user profile", "Error", err)
+			}
+
+			reminder := localized(autoReply.Reminder, profile.LanguageCode)
+			if reminder != "" {
+				support.sendAutoReply(
+					ticket,
+					reminder,
+					autoReplyData{Ticket: ticket.Number, FirstName: profile.FirstName, Minutes: int(waiting.Minutes())},
+					client)
+			}
+
+			_, _, err = support.updateTicket(ticket.GroupChatID, ticket.Number, func(ticket *entity.Ticket) error {
+				ticket.Reminded = true
+				return nil
+			})
+			if err != nil {
+				support.log.Error("Can`t save reminded ticket", "Error", err)
+			}
+		}
+	}
+}
+
+// localized picks the template for the language, falling back from
+// "pt-br" to "pt" and then to the default one.
+func localized(templates map[string]string, language string) string {
+	language = strings.ToLower(language)
+	if text, ok := templates[language]; ok {
+		return text
+	}
+
+	if base, _, found := strings.Cut(language, "-"); found {
+		if text, ok := templates[base]; ok {
+			return text
+		}
+	}
+
+	return templates[defaultLanguage]
+}
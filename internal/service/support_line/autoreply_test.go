@@ -0,0 +1,34 @@
+package supportline
+
+import "testing"
+
+func TestLocalized(t *testing.T) {
+	templates := map[string]string{
+		"default": "Hello",
+		"ru": "Привет",
+		"pt": "Olá",
+		"pt-br": "Oi",
+	}
+
+	if got := localized(templates, "RU"); got != "Привет" {
+		t.Errorf("the language code is case sensitive, got %q", got)
+	}
+
+	if got := localized(templates, "pt-br"); got != "Oi" {
+		t.Errorf("the regional template is %q, want Oi", got)
+	}
+
+	if got := localized(templates, "pt-pt"); got != "Olá" {
+		t.Errorf("a region without a template got %q, want the base language", got)
+	}
+
+	for _, language := range []string{"de", ""} {
+		if got := localized(templates, language); got != "Hello" {
+			t.Errorf("language %q got %q, want the default template", language, got)
+		}
+	}
+
+	if got := localized(map[string]string{"ru": "Привет"}, "de"); got != "" {
+		t.Errorf("got %q without a default template, want no reply", got)
+	}
+}
@@ -0,0 +1,274 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	bansKey = "chatid{%d}:bans"
+	banReplyKey = "chatid{%d}:user:{%d}:ban:reply"
+	banReplyInterval = time.Hour
+	banDateLayout = "2006-01-02 15:04"
+	archiveBanned = "ban"
+)
+
+type banReplyData struct {
+	Until string
+}
+
+// Bans lists the active bans of the tenant, the expired ones are dropped.
+func(support *Support) Bans(chatID int64) ([]entity.Ban, error) {
+	records, err := support.db.Bans(context.Background(), fmt.Sprintf(bansKey, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	bans := make([]entity.Ban, 0, len(records))
+	for _, record := range records {
+		ban, err := entity.NewBanFromJSON([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+
+		if !ban.Active(now) {
+			support.removeBan(chatID, ban.UserID)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].CreatedAt > bans[j].CreatedAt
+	})
+
+	return bans, nil
+}
+
+// Unban lifts the ban of the user and reports whether there was one.
+func(support *Support) Unban(chatID, userID int64) (bool, error) {
+	_, banned, err := support.ban(chatID, userID)
+	if err != nil || !banned {
+		return false, err
+	}
+
+	err = support.db.RemoveBan(
+		context.Background(),
+		fmt.Sprintf(bansKey, chatID),
+		strconv.FormatInt(userID, 10))
+	return err == nil, err
+}
+
+func (support *Support) ban(chatID, userID int64) (entity.Ban, bool, error) {
+	record, err := support.db.Ban(
+		context.Background(),
+		fmt.Sprintf(bansKey, chatID),
+		strconv.FormatInt(userID, 10))
+	if err != nil || record == "" {
+		return entity.Ban{}, false, err
+	}
+
+	ban, err := entity.NewBanFromJSON([]byte(record))
+	if err != nil {
+		return entity.Ban{}, false, err
+	}
+
+	if !ban.Active(time.Now().Unix()) {
+		support.removeBan(chatID, userID)
+		return entity.Ban{}, false, nil
+	}
+
+	return ban, true, nil
+}
+
+func (support *Support) saveBan(ban entity.Ban) error {
+	data, err := encoding.ToJSON(ban)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetBan(
+		context.Background(),
+		fmt.Sprintf(bansKey, ban.GroupChatID),
+		strconv.FormatInt(ban.UserID, 10),
+		string(data))
+}
+
+func (support *Support) removeBan(chatID, userID int64) {
+	err := support.db.RemoveBan(
+		context.Background(),
+		fmt.Sprintf(bansKey, chatID),
+		strconv.FormatInt(userID, 10))
+	if err != nil {
+		support.log.Error("Can`t remove ban", "UserID", userID, "Error", err)
+	}
+}
+
+// rejectBanned drops the message of the banned user and tells whether it
+// did. The user gets the configured reply at most once an hour.
+func (support *Support) rejectBanned(telegramMessage entity.UserMessage, bot *bot.Bot) (bool, error) {
+	ban, banned, err := support.ban(telegramMessage.GroupChatID, telegramMessage.UserID)
+	if err != nil || !banned {
+		return false, err
+	}
+
+	reply := localized(support.settings.Tenant(telegramMessage.GroupChatID).Ban.Reply, telegramMessage.LanguageCode)
+	if reply == "" {
+		return true, nil
+	}
+
+	first, err := support.db.SetAlert(
+		context.Background(),
+		fmt.Sprintf(banReplyKey, telegramMessage.GroupChatID, telegramMessage.UserID),
+		banReplyInterval)
+	if err != nil || !first {
+		return true, err
+	}
+
+	text, err := renderTemplate(reply, banReplyData{Until: support.banUntil(ban)})
+	if err != nil {
+		return true, err
+	}
+
+	_, err = bot.Send(telebot.ChatID(telegramMessage.ChatID), text, &telebot.SendOptions{})
+	return true, err
+}
+
+func (support *Support) banUntil(ban entity.Ban) string {
+	if ban.ExpiresAt == 0 {
+		return ""
+	}
+
+	return time.Unix(ban.ExpiresAt, 0).In(support.tenantLocation(ban.GroupChatID)).Format(banDateLayout)
+}
+
+// removeBannedTopic archives and deletes the topic of the banned user. The
+// topic is kept when the archive fails, so its history isn't lost.
+func (support *Support) removeBannedTopic(topicData entity.TopicData, bot *bot.Bot) error {
+	err := support.archiveTopic(topicData, archiveBanned, bot)
+	if err != nil {
+		support.log.Error("Can`t archive topic of the banned user", "Ticket", topicData.Ticket, "Error", err)
+		return fmt.Errorf("the user is banned, but the topic is kept because it can't be archived: %w", err)
+	}
+
+	err = bot.DeleteTopic(
+		&telebot.Chat{ID: topicData.GroupChatID},
+		&telebot.Topic{ThreadID: topicData.TopicID})
+	if err != nil {
+		return err
+	}
+
+	support.closePurgedTicket(topicData)
+	return support.db.RemoveTopic(
+		context.Background(),
+		fmt.Sprintf(topicSupportKey, topicData.GroupChatID, topicData.TopicID),
+		allTopics,
+		fmt.Sprintf(topicUserKey, topicData.GroupChatID, topicData.UserID),
+		fmt.Sprintf(topicDeliveryKey, topicData.GroupChatID, topicData.TopicID))
+}
+
+// banCommand bans the user of the topic: /ban [duration] [reason],
+// the duration is like 30m, 12h, 7d or 2w, without it the ban is permanent.
+func banCommand(support *Support, cmd command) error {
+	now := time.Now()
+	var expiresAt int64
+	reason := cmd.args
+	first, rest := cutWord(cmd.args)
+	if duration, ok := parseBanDuration(first); ok {
+		expiresAt = now.Add(duration).Unix()
+		reason = rest
+	}
+
+	ban := entity.NewBan(
+		cmd.topic.UserID,
+		cmd.topic.GroupChatID,
+		strings.TrimSpace(reason),
+		cmd.msg.Sender().DisplayName(),
+		now.Unix(),
+		expiresAt)
+	err := support.saveBan(ban)
+	if err != nil {
+		return err
+	}
+
+	if support.settings.Tenant(cmd.topic.GroupChatID).Ban.DeleteTopic {
+		return support.removeBannedTopic(cmd.topic, cmd.bot)
+	}
+
+	text := "The user is banned"
+	if until := support.banUntil(ban); until != "" {
+		text += " until " + until
+	}
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text, cmd.bot)
+	return nil
+}
+
+// unbanCommand lifts the ban of the topic user or of the user by ID:
+// /unban [userID]. The ID works anywhere, so the users whose topic was
+// deleted by the ban can be unbanned as well.
+func unbanCommand(support *Support, cmd command) error {
+	chatID := cmd.msg.ChatID
+	var userID int64
+	if cmd.args != "" {
+		id, err := strconv.ParseInt(cmd.args, 10, 64)
+		if err != nil {
+			return fmt.Errorf("usage: /unban [userID]")
+		}
+		userID = id
+	} else {
+		topicData, err := support.topicByKey(fmt.Sprintf(topicSupportKey, cmd.msg.ChatID, cmd.msg.TopicID))
+		if err != nil {
+			return fmt.Errorf("use /unban <userID> outside of the user topic, see the bans API for the IDs")
+		}
+		chatID = topicData.GroupChatID
+		userID = topicData.UserID
+	}
+
+	banned, err := support.Unban(chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	if !banned {
+		return fmt.Errorf("the user is not banned")
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "The user is unbanned", cmd.bot)
+	return nil
+}
+
+func parseBanDuration(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	count, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || count <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(count) * unit, true
+}
@@ -0,0 +1,28 @@
+package supportline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBanDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"30m": 30 * time.Minute,
+		"12h": 12 * time.Hour,
+		"3d": 3 * 24 * time.Hour,
+		"2w": 14 * 24 * time.Hour,
+	}
+	for value, want := range valid {
+		got, ok := parseBanDuration(value)
+		if !ok || got != want {
+			t.Errorf("parseBanDuration(%q) = %s, %t, want %s", value, got, ok, want)
+		}
+	}
+
+	// Anything else is the first word of the ban reason.
+	for _, value := range []string{"", "d", "10", "10s", "0d", "-1d", "xd", "spam"} {
+		if got, ok := parseBanDuration(value); ok {
+			t.Errorf("parseBanDuration(%q) = %s, want no duration", value, got)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package supportline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	userProfileKey = "chatid{%d}:user:{%d}:profile"
+	cardDateLayout = "2006-01-02"
+)
+
+type cardData struct {
+	Profile entity.UserProfile
+	Ticket entity.Ticket
+	Assignee string
+}
+
+func (support *Support) profile(chatID, userID int64) (entity.UserProfile, bool, error) {
+	data, err := support.db.Profile(
+		context.Background(),
+		fmt.Sprintf(userProfileKey, chatID, userID))
+	if err != nil || data == "" {
+		return entity.UserProfile{}, false, err
+	}
+
+	jsonProfile, err := crypto.DecryptData(data)
+	if err != nil {
+		return entity.UserProfile{}, false, err
+	}
+
+	profile, err := entity.NewUserProfileFromJSON([]byte(jsonProfile))
+	return profile, err == nil, err
+}
+
+func (support *Support) saveProfile(chatID int64, profile entity.UserProfile) error {
+	data, err := encoding.ToJSON(profile)
+	if err != nil {
+		return err
+	}
+
+	encryptProfile, err := crypto.EncryptData(data)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetProfile(
+		context.Background(),
+		fmt.Sprintf(userProfileKey, chatID, profile.UserID),
+		encryptProfile)
+}
+
+// loadProfile returns the stored user profile refreshed with the message
+// details, or a new one for the first contact.
+func (support *Support) loadProfile(telegramMessage entity.UserMessage) (entity.UserProfile, error) {
+	profile, found, err := support.profile(telegramMessage.GroupChatID, telegramMessage.UserID)
+	if err != nil {
+		return entity.UserProfile{}, err
+	}
+
+	if !found {
+		profile = entity.NewUserProfile(telegramMessage, time.Now().Unix())
+	}
+	profile.Update(telegramMessage)
+
+	return profile, nil
+}
+
+func (support *Support) postCard(data cardData, topicID int, bot *bot.Bot, supportChat *telebot.Chat) (int, error) {
+	card, err := bot.Send(
+		supportChat,
+		profileCard(data),
+		&telebot.SendOptions{
+			ThreadID: topicID,
+			DisableNotification: true,
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	return card.ID, bot.Pin(card, telebot.Silent)
+}
+
+func (support *Support) refreshCard(topicData entity.TopicData, bot *bot.Bot) error {
+	if topicData.CardMessageID == 0 {
+		return nil
+	}
+
+	data, err := support.cardData(topicData)
+	if err != nil {
+		return err
+	}
+
+	_, err = bot.EditMessage(
+		&telebot.Message{
+			ID: topicData.CardMessageID,
+			Chat: &telebot.Chat{ID: topicData.GroupChatID},
+		},
+		profileCard(data))
+	if errors.Is(err, telebot.ErrMessageNotModified) || errors.Is(err, telebot.ErrSameMessageContent) {
+		err = nil
+	}
+
+	return err
+}
+
+func (support *Support) cardData(topicData entity.TopicData) (cardData, error) {
+	profile, _, err := support.profile(topicData.GroupChatID, topicData.UserID)
+	if err != nil {
+		return cardData{}, err
+	}
+
+	ticket, _, err := support.ticket(topicData.GroupChatID, topicData.Ticket)
+	if err != nil {
+		return cardData{}, err
+	}
+
+	return cardData{
+		Profile: profile,
+		Ticket: ticket,
+		Assignee: support.assigneeName(ticket),
+	}, nil
+}
+
+// syncProfile keeps the stored profile and the card in line with the
+// user details of the latest message.
+func (support *Support) syncProfile(telegramMessage entity.UserMessage, topicData entity.TopicData, bot *bot.Bot) {
+	profile, found, err := support.profile(telegramMessage.GroupChatID, telegramMessage.UserID)
+	if err != nil || !found {
+		return
+	}
+
+	if !profile.Update(telegramMessage) {
+		return
+	}
+
+	err = support.saveProfile(telegramMessage.GroupChatID, profile)
+	if err != nil {
+		support.log.Error("Can`t save user profile", "Error", err)
+		return
+	}
+
+	err = support.refreshCard(topicData, bot)
+	if err != nil {
+		support.log.Error("Can`t update profile card", "Error", err)
+	}
+}
+
+func profileCard(data cardData) string {
+	profile := data.Profile
+	var card strings.Builder
+
+	name := strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+	if name == "" {
+		name = "Unknown user"
+	}
+
+	fmt.Fprintf(&card, "👤 %s\n", name)
+	fmt.Fprintf(&card, "ID: %d\n", profile.UserID)
+	if profile.UserName != "" {
+		fmt.Fprintf(&card, "Username: @%s\n", profile.UserName)
+	}
+	if profile.LanguageCode != "" {
+		fmt.Fprintf(&card, "Language: %s\n", profile.LanguageCode)
+	}
+	fmt.Fprintf(&card, "Premium: %s\n", yesNo(profile.IsPremium))
+	if profile.FirstContact != 0 {
+		fmt.Fprintf(&card, "First contact: %s\n", time.Unix(profile.FirstContact, 0).Format(cardDateLayout))
+	}
+	fmt.Fprintf(&card, "Previous tickets: %d\n", max(profile.TicketCount-1, 0))
+	if data.Ticket.PreviousTicket != 0 {
+		fmt.Fprintf(&card, "Previous ticket: #%d\n", data.Ticket.PreviousTicket)
+	}
+	if profile.LastCSAT != 0 {
+		fmt.Fprintf(&card, "Last CSAT: %d/%d\n", profile.LastCSAT, maxScore)
+	}
+	fmt.Fprintf(&card, "Ticket: #%d (%s)\n", data.Ticket.Number, data.Ticket.Status)
+	if data.Ticket.RepeatContact {
+		card.WriteString("🔁 Repeat contact\n")
+	}
+	if data.Ticket.Category != "" {
+		fmt.Fprintf(&card, "Category: %s\n", data.Ticket.Category)
+	}
+	for _, answer := range data.Ticket.Answers {
+		fmt.Fprintf(&card, "%s: %s\n", answer.Name, answer.Value)
+	}
+	if data.Assignee != "" {
+		fmt.Fprintf(&card, "Assignee: %s\n", data.Assignee)
+	}
+	if len(profile.Tags) > 0 {
+		fmt.Fprintf(&card, "Tags: %s\n", strings.Join(profile.Tags, ", "))
+	}
+	if profile.Notes != "" {
+		fmt.Fprintf(&card, "Notes: %s\n", profile.Notes)
+	}
+
+	return strings.TrimRight(card.String(), "\n")
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
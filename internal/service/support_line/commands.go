@@ -0,0 +1,120 @@
+package supportline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+type command struct {
+	name string
+	args string
+	msg entity.SupportMessage
+	topic entity.TopicData
+	bot *bot.Bot
+}
+
+type commandFunc func(support *Support, cmd command) error
+
+var topicCommands = map[string]commandFunc{
+	"resolve": statusCommand(entity.TicketResolved),
+	"pending": statusCommand(entity.TicketPendingUser),
+	"reopen": statusCommand(entity.TicketOpen),
+	"close": statusCommand(entity.TicketClosed),
+	"assign": assignCommand,
+	"take": takeCommand,
+	"unassign": unassignCommand,
+	"priority": priorityCommand,
+	"sla": slaCommand,
+	"m": macroCommand,
+	"tag": tagCommand(true),
+	"untag": tagCommand(false),
+	"ban": banCommand,
+}
+
+var adminCommands = map[string]commandFunc{
+	"agent": agentCommand,
+	"agents": agentsCommand,
+	"onduty": dutyCommand(entity.DutyOn),
+	"offduty": dutyCommand(entity.DutyOff),
+	"shift": shiftCommand,
+	"roster": rosterCommand,
+	"macro": macroAdminCommand,
+	"macros": macrosCommand,
+	"search": searchCommand,
+	"unban": unbanCommand,
+	"faq": faqCommand,
+	"faqs": faqsCommand,
+}
+
+// parseCommand splits "/name@bot args" into the lower-cased name and args.
+func parseCommand(payload string) (string, string, bool) {
+	payload = strings.TrimSpace(payload)
+	if !strings.HasPrefix(payload, "/") {
+		return "", "", false
+	}
+
+	name, args, _ := strings.Cut(payload[1:], " ")
+	name, _, _ = strings.Cut(name, "@")
+	if name == "" {
+		return "", "", false
+	}
+
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// runAdminCommand executes the tenant-wide command typed anywhere in the
+// support chat and reports whether the message was a command.
+func (support *Support) runAdminCommand(supportMsg entity.SupportMessage, bot *bot.Bot) bool {
+	return support.runCommand(adminCommands, supportMsg, entity.TopicData{}, bot)
+}
+
+// runTopicCommand executes the agent command typed in the topic and reports
+// whether the message was a command.
+func (support *Support) runTopicCommand(supportMsg entity.SupportMessage, topicData entity.TopicData, bot *bot.Bot) bool {
+	return support.runCommand(topicCommands, supportMsg, topicData, bot)
+}
+
+func (support *Support) runCommand(commands map[string]commandFunc, supportMsg entity.SupportMessage, topicData entity.TopicData, bot *bot.Bot) bool {
+	name, args, ok := parseCommand(supportMsg.Payload)
+	if !ok {
+		return false
+	}
+
+	handler, ok := commands[name]
+	if !ok {
+		return false
+	}
+
+	err := handler(support, command{
+		name: name,
+		args: args,
+		msg: supportMsg,
+		topic: topicData,
+		bot: bot,
+	})
+	if err != nil {
+		support.log.Error("Command failed", "Command", name, "Error", err)
+		support.notifyTopic(supportMsg.ChatID, supportMsg.TopicID, fmt.Sprintf("⚠️ /%s: %s", name, err), bot)
+	}
+
+	return true
+}
+
+func statusCommand(status string) commandFunc {
+	return func(support *Support, cmd command) error {
+		ticket, err := support.changeStatus(cmd.topic, status, cmd.bot)
+		if err != nil {
+			return err
+		}
+
+		support.notifyTopic(
+			cmd.msg.ChatID,
+			cmd.msg.TopicID,
+			fmt.Sprintf("Ticket #%d is %s", ticket.Number, ticket.Status),
+			cmd.bot)
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package supportline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+// markRepeatContact links the new ticket to the previous one of the user and
+// flags it when the user comes back soon after an agent resolved the
+// previous one. Tickets closed by the purge have no ResolvedAt.
+func (support *Support) markRepeatContact(ticket *entity.Ticket, profile entity.UserProfile) {
+	ticket.PreviousTicket = profile.LastTicket
+	hours := support.settings.Tenant(ticket.GroupChatID).RepeatContactHours
+	if hours <= 0 || profile.LastTicket == 0 {
+		return
+	}
+
+	previous, found, err := support.ticket(ticket.GroupChatID, profile.LastTicket)
+	if err != nil {
+		support.log.Error("Can`t load the previous ticket", "Ticket", profile.LastTicket, "Error", err)
+		return
+	}
+
+	ticket.RepeatContact = found &&
+		previous.ResolvedAt != 0 &&
+		ticket.CreatedAt-previous.ResolvedAt <= int64(hours)*3600
+}
+
+func (support *Support) noticeRepeatContact(ticket entity.Ticket, bot *bot.Bot) {
+	if !ticket.RepeatContact {
+		return
+	}
+
+	hours := support.settings.Tenant(ticket.GroupChatID).RepeatContactHours
+	support.notifyTopic(
+		ticket.GroupChatID,
+		ticket.TopicID,
+		fmt.Sprintf("🔁 Repeat contact: the user is back within %dh after ticket #%d was resolved", hours, ticket.PreviousTicket),
+		bot)
+}
+
+func (support *Support) recordCSAT(ticket entity.Ticket, score int) {
+	profile, found, err := support.profile(ticket.GroupChatID, ticket.UserID)
+	if err != nil || !found {
+		return
+	}
+
+	profile.LastCSAT = score
+	err = support.saveProfile(ticket.GroupChatID, profile)
+	if err != nil {
+		support.log.Error("Can`t save user profile", "Error", err)
+	}
+}
+
+// tagCommand adds or removes the user tags: /tag <tag>... or /untag <tag>...
+func tagCommand(add bool) commandFunc {
+	return func(support *Support, cmd command) error {
+		tags := strings.Fields(strings.ToLower(cmd.args))
+		if len(tags) == 0 {
+			return fmt.Errorf("usage: /%s <tag>...", cmd.name)
+		}
+
+		profile, found, err := support.profile(cmd.topic.GroupChatID, cmd.topic.UserID)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("profile of the user %d not found", cmd.topic.UserID)
+		}
+
+		if add {
+			profile.Tag(tags...)
+		} else {
+			profile.Untag(tags...)
+		}
+
+		err = support.saveProfile(cmd.topic.GroupChatID, profile)
+		if err != nil {
+			return err
+		}
+
+		return support.refreshCard(cmd.topic, cmd.bot)
+	}
+}
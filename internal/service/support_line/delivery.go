@@ -0,0 +1,103 @@
+package supportline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	topicDeliveryKey = "chatid{%d}:topic:{%d}:delivery"
+	deliveryLogLimit = 100
+)
+
+func(support *Support) Deliveries(chatID int64, topicID int) ([]entity.Delivery, error) {
+	records, err := support.db.Deliveries(
+		context.Background(),
+		fmt.Sprintf(topicDeliveryKey, chatID, topicID))
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]entity.Delivery, 0, len(records))
+	for _, record := range records {
+		delivery, err := entity.NewDeliveryFromJSON([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func (support *Support) reportDelivery(supportMsg entity.SupportMessage, deliveryErr error, bot *bot.Bot) {
+	delivery := entity.NewDelivery(
+		supportMsg.TopicID,
+		supportMsg.MessageID,
+		entity.DeliveryDelivered,
+		"",
+		time.Now().Unix())
+
+	if deliveryErr != nil {
+		delivery.Status = entity.DeliveryFailed
+		delivery.Reason = deliveryFailureReason(deliveryErr)
+	}
+
+	record, err := encoding.ToJSON(delivery)
+	if err != nil {
+		support.log.Error("Can`t encode delivery record", "Error", err)
+		return
+	}
+
+	err = support.db.AddDelivery(
+		context.Background(),
+		fmt.Sprintf(topicDeliveryKey, supportMsg.ChatID, supportMsg.TopicID),
+		string(record),
+		deliveryLogLimit)
+	if err != nil {
+		support.log.Error("Can`t save delivery record", "Error", err)
+	}
+
+	if deliveryErr == nil {
+		return
+	}
+
+	_, err = bot.Send(
+		telebot.ChatID(supportMsg.ChatID),
+		fmt.Sprintf("⚠️ The message was not delivered: %s", delivery.Reason),
+		&telebot.SendOptions{
+			ThreadID: supportMsg.TopicID,
+			ReplyTo: &telebot.Message{ID: supportMsg.MessageID},
+			AllowWithoutReply: true,
+		})
+	if err != nil {
+		support.log.Error("Can`t post delivery notice to the topic", "Error", err)
+	}
+}
+
+func deliveryFailureReason(err error) string {
+	switch {
+	case errors.Is(err, telebot.ErrBlockedByUser):
+		return "the user blocked the bot"
+	case errors.Is(err, telebot.ErrUserIsDeactivated):
+		return "the user deleted their account"
+	case errors.Is(err, telebot.ErrNotStartedByUser):
+		return "the user has not started the bot"
+	case errors.Is(err, telebot.ErrChatNotFound):
+		return "the user chat was not found"
+	case errors.Is(err, telebot.ErrTooLongMessage):
+		return "the message is too long"
+	case errors.Is(err, telebot.ErrEmptyText), errors.Is(err, telebot.ErrEmptyMessage):
+		return "the message is empty"
+	default:
+		return err.Error()
+	}
+}
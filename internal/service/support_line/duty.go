@@ -0,0 +1,258 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	shiftsKey = "chatid{%d}:shifts"
+	agentDutyKey = "chatid{%d}:agent:{%s}:duty"
+	defaultDutyOverride = 12 * time.Hour
+)
+
+func(support *Support) Roster(chatID int64) ([]entity.Duty, error) {
+	return support.duties(chatID, time.Now())
+}
+
+// duties tells for every agent of the roster whether they are working now.
+// A manual /onduty or /offduty wins over the shift schedule.
+func (support *Support) duties(chatID int64, now time.Time) ([]entity.Duty, error) {
+	agents, err := support.roster(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	shifts, err := support.shifts(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.In(support.tenantLocation(chatID))
+	duties := make([]entity.Duty, 0, len(agents))
+	for _, agent := range agents {
+		status, err := support.db.DutyStatus(
+			context.Background(),
+			fmt.Sprintf(agentDutyKey, chatID, agent.Key()))
+		if err != nil {
+			return nil, err
+		}
+
+		duty := entity.Duty{Agent: agent, Reason: "off shift"}
+		switch status {
+		case entity.DutyOn:
+			duty.OnDuty = true
+			duty.Reason = "/onduty"
+		case entity.DutyOff:
+			duty.Reason = "/offduty"
+		default:
+			for _, shift := range shifts[agent.Key()] {
+				if shift.Covers(now) {
+					duty.OnDuty = true
+					duty.Reason = "shift " + shift.String()
+					break
+				}
+			}
+		}
+
+		duties = append(duties, duty)
+	}
+
+	return duties, nil
+}
+
+func (support *Support) onDutyAgents(chatID int64) ([]entity.Agent, error) {
+	duties, err := support.duties(chatID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var agents []entity.Agent
+	for _, duty := range duties {
+		if duty.OnDuty {
+			agents = append(agents, duty.Agent)
+		}
+	}
+
+	return agents, nil
+}
+
+func (support *Support) shifts(chatID int64) (map[string][]entity.Shift, error) {
+	records, err := support.db.Shifts(context.Background(), fmt.Sprintf(shiftsKey, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	shifts := make(map[string][]entity.Shift, len(records))
+	for agentKey, record := range records {
+		agentShifts, err := entity.NewShiftsFromJSON([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		shifts[agentKey] = agentShifts
+	}
+
+	return shifts, nil
+}
+
+func (support *Support) tenantLocation(chatID int64) *time.Location {
+	timezone := support.settings.Tenant(chatID).Timezone
+	if timezone == "" {
+		return support.location
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		support.log.Error("Invalid tenant timezone", "ChatID", chatID, "Error", err)
+		return support.location
+	}
+
+	return loc
+}
+
+func (support *Support) warnNobodyOnDuty(ticket entity.Ticket, bot *bot.Bot) {
+	agents, err := support.onDutyAgents(ticket.GroupChatID)
+	if err != nil {
+		support.log.Error("Can`t load on-duty agents", "Error", err)
+		return
+	}
+
+	if len(agents) > 0 {
+		return
+	}
+
+	support.alertOps(
+		ticket.GroupChatID,
+		fmt.Sprintf("⚠️ New ticket #%d in the support chat %d, but nobody is on duty", ticket.Number, ticket.GroupChatID),
+		bot)
+}
+
+func dutyCommand(status string) commandFunc {
+	return func(support *Support, cmd command) error {
+		sender := cmd.msg.Sender()
+		agent, found, err := support.rosterAgent(cmd.msg.ChatID, sender.Key())
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("you are not in the roster, ask an admin to run /agent add @%s", sender.UserName)
+		}
+
+		override := defaultDutyOverride
+		if hours := support.settings.Tenant(cmd.msg.ChatID).DutyOverrideHours; hours > 0 {
+			override = time.Duration(hours) * time.Hour
+		}
+
+		err = support.db.SetDutyStatus(
+			context.Background(),
+			fmt.Sprintf(agentDutyKey, cmd.msg.ChatID, agent.Key()),
+			status,
+			override)
+		if err != nil {
+			return err
+		}
+
+		support.notifyTopic(
+			cmd.msg.ChatID,
+			cmd.msg.TopicID,
+			fmt.Sprintf("%s is %s duty", agent.DisplayName(), status),
+			cmd.bot)
+		return nil
+	}
+}
+
+func shiftCommand(support *Support, cmd command) error {
+	fields := strings.Fields(cmd.args)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "@") {
+		return fmt.Errorf("usage: /shift add @agent mon,tue 09:00-18:00 or /shift clear @agent")
+	}
+
+	agent, found, err := support.rosterAgent(cmd.msg.ChatID, fields[1])
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("%s is not in the roster", fields[1])
+	}
+
+	key := fmt.Sprintf(shiftsKey, cmd.msg.ChatID)
+	switch fields[0] {
+	case "add":
+		if len(fields) != 4 {
+			return fmt.Errorf("usage: /shift add @agent mon,tue 09:00-18:00")
+		}
+
+		start, end, _ := strings.Cut(fields[3], "-")
+		shift, err := entity.NewShift(strings.Split(fields[2], ","), start, end)
+		if err != nil {
+			return err
+		}
+
+		shifts, err := support.shifts(cmd.msg.ChatID)
+		if err != nil {
+			return err
+		}
+
+		data, err := encoding.ToJSON(append(shifts[agent.Key()], shift))
+		if err != nil {
+			return err
+		}
+
+		err = support.db.SetShifts(context.Background(), key, agent.Key(), string(data))
+		if err != nil {
+			return err
+		}
+	case "clear":
+		err = support.db.RemoveShifts(context.Background(), key, agent.Key())
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action %s, use add or clear", fields[0])
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "Shifts updated", cmd.bot)
+	return nil
+}
+
+func rosterCommand(support *Support, cmd command) error {
+	duties, err := support.Roster(cmd.msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	shifts, err := support.shifts(cmd.msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	if len(duties) == 0 {
+		support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "The roster is empty", cmd.bot)
+		return nil
+	}
+
+	var text strings.Builder
+	text.WriteString("Roster:")
+	for _, duty := range duties {
+		mark := "⚪️"
+		if duty.OnDuty {
+			mark = "🟢"
+		}
+		fmt.Fprintf(&text, "\n%s %s (%s)", mark, duty.Agent.DisplayName(), duty.Reason)
+
+		for _, shift := range shifts[duty.Agent.Key()] {
+			fmt.Fprintf(&text, "\n    %s", shift)
+		}
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text.String(), cmd.bot)
+	return nil
+}
@@ -0,0 +1,131 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/internal/service/export"
+)
+
+// Transcripts loads the transcript of the ticket or of every ticket created
+// in the period of the filter.
+func(support *Support) Transcripts(chatID int64, filter entity.TranscriptFilter) ([]entity.Transcript, error) {
+	transcripts, err := support.transcripts(chatID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range transcripts {
+		transcripts[i].Ticket = transcripts[i].Ticket.WithoutToken()
+	}
+
+	return transcripts, nil
+}
+
+func(support *Support) transcripts(chatID int64, filter entity.TranscriptFilter) ([]entity.Transcript, error) {
+	numbers := []int64{filter.Ticket}
+	if filter.Ticket == 0 {
+		records, err := support.db.TicketNumbers(
+			context.Background(),
+			fmt.Sprintf(ticketsKey, chatID),
+			filter.From.Unix(),
+			filter.To.Unix())
+		if err != nil {
+			return nil, err
+		}
+
+		numbers = numbers[:0]
+		for _, record := range records {
+			number, err := strconv.ParseInt(record, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			numbers = append(numbers, number)
+		}
+	}
+
+	transcripts := make([]entity.Transcript, 0, len(numbers))
+	for _, number := range numbers {
+		ticket, found, err := support.ticket(chatID, number)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			if filter.Ticket != 0 {
+				return nil, fmt.Errorf("ticket #%d not found", number)
+			}
+			continue
+		}
+
+		entries, err := support.History(chatID, number)
+		if err != nil {
+			return nil, err
+		}
+
+		transcripts = append(transcripts, entity.Transcript{Ticket: ticket, Entries: entries})
+	}
+
+	return transcripts, nil
+}
+
+// Export writes the transcripts as JSON, CSV or HTML. The bundle is a zip
+// archive with the attachments downloaded by the bots that received them.
+func(support *Support) Export(w io.Writer, chatID int64, filter entity.TranscriptFilter, format string, bundle bool) error {
+	if !export.IsFormat(format) {
+		return fmt.Errorf("unknown export format %s, use json, csv or html", format)
+	}
+
+	transcripts, err := support.transcripts(chatID, filter)
+	if err != nil {
+		return err
+	}
+
+	tokens := make(map[string]string)
+	for i, transcript := range transcripts {
+		token := transcript.Ticket.BotToken
+		if token == "" {
+			token = support.token
+		}
+		for _, id := range transcript.FileIDs() {
+			tokens[id] = token
+		}
+		transcripts[i].Ticket = transcript.Ticket.WithoutToken()
+	}
+
+	if !bundle {
+		return export.Render(w, format, transcripts)
+	}
+
+	bots := make(map[string]*bot.Bot)
+	defer func() {
+		for _, client := range bots {
+			client.Close()
+		}
+	}()
+
+	fetch := func(fileID string) (io.ReadCloser, error) {
+		token := tokens[fileID]
+		if token == "" {
+			return nil, fmt.Errorf("the bot of the ticket is unknown")
+		}
+
+		client, ok := bots[token]
+		if !ok {
+			var err error
+			client, err = bot.New(support.log, token, support.timeout)
+			if err != nil {
+				return nil, err
+			}
+			bots[token] = client
+		}
+
+		return client.File(fileID)
+	}
+
+	return export.Bundle(w, format, transcripts, fetch)
+}
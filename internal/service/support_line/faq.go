@@ -0,0 +1,454 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/internal/service/knowledge"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	articlesKey = "chatid{%d}:faq"
+	faqStateKey = "chatid{%d}:user:{%d}:faq"
+	faqStatsKey = "chatid{%d}:faq:stats:{%s}"
+	faqAction = "faq"
+	faqHelped = "helped"
+	faqHuman = "human"
+	faqSeparator = "|"
+	faqDateLayout = "2006-01-02"
+	// faqStatsRetention keeps the daily stats for the yearly reports.
+	faqStatsRetention = 400 * 24 * time.Hour
+	defaultFAQAnswers = 3
+	defaultFAQScore = 0.35
+	defaultFAQTimeout = 30 * time.Minute
+	defaultFAQIntro = "Maybe one of these answers helps:"
+	defaultFAQThanks = "Glad it helped!"
+	defaultFAQHelped = "✅ This helped"
+	defaultFAQHuman = "🙋 Talk to a human"
+	faqExpired = "The answers have expired, please send your message again."
+)
+
+func(support *Support) Articles(chatID int64) ([]entity.Article, error) {
+	records, err := support.db.Articles(context.Background(), fmt.Sprintf(articlesKey, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	articles := make([]entity.Article, 0, len(records))
+	for _, record := range records {
+		article, err := entity.NewArticleFromJSON([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+
+	sort.Slice(articles, func(i, j int) bool {
+		return articles[i].ID < articles[j].ID
+	})
+
+	return articles, nil
+}
+
+func(support *Support) SaveArticle(chatID int64, article entity.Article) error {
+	article.ID = strings.ToLower(article.ID)
+	if !macroName.MatchString(article.ID) {
+		return fmt.Errorf("invalid article id %s, use up to 32 latin letters, digits, - or _", article.ID)
+	}
+
+	article.Question = strings.TrimSpace(article.Question)
+	article.Answer = strings.TrimSpace(article.Answer)
+	if article.Question == "" || article.Answer == "" {
+		return fmt.Errorf("article %s needs a question and an answer", article.ID)
+	}
+
+	data, err := encoding.ToJSON(article)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetArticle(
+		context.Background(),
+		fmt.Sprintf(articlesKey, chatID),
+		article.ID,
+		string(data))
+}
+
+func(support *Support) RemoveArticle(chatID int64, id string) error {
+	return support.db.RemoveArticle(
+		context.Background(),
+		fmt.Sprintf(articlesKey, chatID),
+		strings.ToLower(id))
+}
+
+func (support *Support) article(chatID int64, id string) (entity.Article, bool, error) {
+	record, err := support.db.Article(
+		context.Background(),
+		fmt.Sprintf(articlesKey, chatID),
+		strings.ToLower(id))
+	if err != nil || record == "" {
+		return entity.Article{}, false, err
+	}
+
+	article, err := entity.NewArticleFromJSON([]byte(record))
+	return article, err == nil, err
+}
+
+// FAQReport sums up the offers of the knowledge base for the days of the
+// period.
+func(support *Support) FAQReport(chatID int64, from, to time.Time) (entity.FAQReport, error) {
+	keys, err := support.db.Keys(context.Background(), fmt.Sprintf(faqStatsKey, chatID, "*"))
+	if err != nil {
+		return entity.FAQReport{}, err
+	}
+
+	first := fmt.Sprintf(faqStatsKey, chatID, from.UTC().Format(faqDateLayout))
+	last := fmt.Sprintf(faqStatsKey, chatID, to.UTC().Format(faqDateLayout))
+	days := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key >= first && key <= last {
+			days = append(days, key)
+		}
+	}
+
+	if len(days) == 0 {
+		return entity.NewFAQReport(nil), nil
+	}
+
+	stats, err := support.db.Stats(context.Background(), days...)
+	if err != nil {
+		return entity.FAQReport{}, err
+	}
+
+	return entity.NewFAQReport(stats), nil
+}
+
+// resumeFAQ returns the messages held by the FAQ offer. A message sent
+// instead of pressing a button asks for a human as well.
+func (support *Support) resumeFAQ(telegramMessage entity.UserMessage) (entity.TriageState, bool, error) {
+	state, found, err := support.takeFAQState(telegramMessage.GroupChatID, telegramMessage.UserID)
+	if err != nil || !found {
+		return entity.TriageState{}, false, err
+	}
+
+	support.countFAQ(telegramMessage.GroupChatID, entity.FAQEscalated)
+	if len(state.Triage.Pending) < triagePendingLimit {
+		state.Triage.Pending = append(state.Triage.Pending, telegramMessage)
+	}
+
+	return state.Triage, true, nil
+}
+
+// offerFAQ sends the articles matching the messages and holds them back
+// until the user asks for a human. It reports whether the topic can be
+// created right away.
+func (support *Support) offerFAQ(triage entity.TriageState, bot *bot.Bot) (bool, error) {
+	first := triage.Pending[0]
+	settings := support.settings.Tenant(first.GroupChatID).FAQ
+	if !settings.Enabled {
+		return true, nil
+	}
+
+	articles, err := support.matchArticles(first.GroupChatID, pendingText(triage.Pending), settings)
+	if err != nil || len(articles) == 0 {
+		return true, err
+	}
+
+	state := entity.FAQState{Triage: triage}
+	stats := []string{entity.FAQOffers}
+	for _, article := range articles {
+		state.Articles = append(state.Articles, article.ID)
+		stats = append(stats, entity.ArticleStat(entity.FAQOffers, article.ID))
+	}
+
+	err = support.saveFAQState(first.GroupChatID, first.UserID, state)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = bot.Send(
+		telebot.ChatID(first.ChatID),
+		faqText(articles, settings, first.LanguageCode),
+		&telebot.SendOptions{
+			ReplyMarkup: &telebot.ReplyMarkup{InlineKeyboard: faqKeyboard(articles, settings, first.LanguageCode)},
+		})
+	if err != nil {
+		support.log.Error("Can`t offer faq articles", "Error", err)
+		return true, support.removeFAQState(first.GroupChatID, first.UserID)
+	}
+
+	support.countFAQ(first.GroupChatID, stats...)
+	return false, nil
+}
+
+// answerFAQ handles the buttons of the FAQ offer: the article helped the
+// user or they want to talk to a human. The state is taken atomically, so
+// only the first answer counts.
+func (support *Support) answerFAQ(callback entity.CallbackMessage, args []string, bot *bot.Bot) error {
+	if len(args) == 0 || (args[0] != faqHelped && args[0] != faqHuman) {
+		return fmt.Errorf("invalid faq callback %s", callback.Data)
+	}
+
+	state, found, err := support.takeFAQState(callback.GroupChatID, callback.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return bot.Respond(callback.CallbackID, faqExpired)
+	}
+
+	_, err = bot.EditReplyMarkup(
+		&telebot.Message{ID: callback.MessageID, Chat: &telebot.Chat{ID: callback.ChatID}},
+		nil)
+	if err != nil {
+		support.log.Error("Can`t remove faq buttons", "Error", err)
+	}
+
+	settings := support.settings.Tenant(callback.GroupChatID).FAQ
+	language := state.Triage.Pending[0].LanguageCode
+	if args[0] == faqHelped {
+		stats := []string{entity.FAQHelped}
+		if len(args) > 1 {
+			stats = append(stats, entity.ArticleStat(entity.FAQHelped, args[1]))
+		}
+		support.countFAQ(callback.GroupChatID, stats...)
+
+		thanks := localized(settings.Thanks, language)
+		if thanks == "" {
+			thanks = defaultFAQThanks
+		}
+		return bot.Respond(callback.CallbackID, thanks)
+	}
+
+	support.countFAQ(callback.GroupChatID, entity.FAQEscalated)
+	err = bot.Respond(callback.CallbackID, "")
+	if err != nil {
+		support.log.Error("Can`t answer faq callback", "Error", err)
+	}
+
+	supportChat, err := bot.ChatByID(callback.GroupChatID)
+	if err != nil {
+		return err
+	}
+
+	return support.openTriagedTopic(state.Triage, bot, supportChat)
+}
+
+func (support *Support) matchArticles(chatID int64, text string, settings config.FAQConfig) ([]entity.Article, error) {
+	articles, err := support.Articles(chatID)
+	if err != nil || len(articles) == 0 {
+		return nil, err
+	}
+
+	docs := make([]knowledge.Document, 0, len(articles))
+	byID := make(map[string]entity.Article, len(articles))
+	for _, article := range articles {
+		docs = append(docs, knowledge.Document{ID: article.ID, Text: article.Question, Keywords: article.Keywords})
+		byID[article.ID] = article
+	}
+
+	limit := settings.MaxAnswers
+	if limit <= 0 {
+		limit = defaultFAQAnswers
+	}
+
+	minScore := settings.MinScore
+	if minScore <= 0 {
+		minScore = defaultFAQScore
+	}
+
+	matches := knowledge.NewIndex(docs).Match(text, limit, minScore)
+	result := make([]entity.Article, 0, len(matches))
+	for _, match := range matches {
+		result = append(result, byID[match.ID])
+	}
+
+	return result, nil
+}
+
+func (support *Support) countFAQ(chatID int64, stats ...string) {
+	err := support.db.IncrementStats(
+		context.Background(),
+		fmt.Sprintf(faqStatsKey, chatID, time.Now().UTC().Format(faqDateLayout)),
+		faqStatsRetention,
+		stats...)
+	if err != nil {
+		support.log.Error("Can`t count faq stats", "Error", err)
+	}
+}
+
+func (support *Support) takeFAQState(chatID, userID int64) (entity.FAQState, bool, error) {
+	data, err := support.db.TakeUserState(context.Background(), fmt.Sprintf(faqStateKey, chatID, userID))
+	if err != nil || data == "" {
+		return entity.FAQState{}, false, err
+	}
+
+	jsonState, err := crypto.DecryptData(data)
+	if err != nil {
+		return entity.FAQState{}, false, err
+	}
+
+	state, err := entity.NewFAQStateFromJSON([]byte(jsonState))
+	return state, err == nil && len(state.Triage.Pending) > 0, err
+}
+
+func (support *Support) saveFAQState(chatID, userID int64, state entity.FAQState) error {
+	data, err := encoding.ToJSON(state)
+	if err != nil {
+		return err
+	}
+
+	encryptState, err := crypto.EncryptData(data)
+	if err != nil {
+		return err
+	}
+
+	timeout := defaultFAQTimeout
+	if minutes := support.settings.Tenant(chatID).FAQ.TimeoutMinutes; minutes > 0 {
+		timeout = time.Duration(minutes) * time.Minute
+	}
+
+	return support.db.SetUserState(
+		context.Background(),
+		fmt.Sprintf(faqStateKey, chatID, userID),
+		encryptState,
+		timeout)
+}
+
+func (support *Support) removeFAQState(chatID, userID int64) error {
+	return support.db.DeleteKey(context.Background(), fmt.Sprintf(faqStateKey, chatID, userID))
+}
+
+func faqText(articles []entity.Article, settings config.FAQConfig, language string) string {
+	intro := localized(settings.Intro, language)
+	if intro == "" {
+		intro = defaultFAQIntro
+	}
+
+	var text strings.Builder
+	text.WriteString(intro)
+	for i, article := range articles {
+		fmt.Fprintf(&text, "\n\n%d. %s\n%s", i+1, article.Question, article.Answer)
+	}
+
+	return text.String()
+}
+
+func faqKeyboard(articles []entity.Article, settings config.FAQConfig, language string) [][]telebot.InlineButton {
+	helped := localized(settings.HelpedButton, language)
+	if helped == "" {
+		helped = defaultFAQHelped
+	}
+
+	human := localized(settings.HumanButton, language)
+	if human == "" {
+		human = defaultFAQHuman
+	}
+
+	buttons := make([]telebot.InlineButton, 0, len(articles))
+	for i, article := range articles {
+		text := helped
+		if len(articles) > 1 {
+			text = fmt.Sprintf("%s: %d", helped, i+1)
+		}
+		buttons = append(buttons, telebot.InlineButton{
+			Text: text,
+			Data: entity.NewCallbackData(faqAction, faqHelped, article.ID),
+		})
+	}
+
+	return [][]telebot.InlineButton{
+		buttons,
+		{{Text: human, Data: entity.NewCallbackData(faqAction, faqHuman)}},
+	}
+}
+
+func pendingText(messages []entity.UserMessage) string {
+	parts := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Payload != "" {
+			parts = append(parts, msg.Payload)
+		}
+		if msg.Caption != "" {
+			parts = append(parts, msg.Caption)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// faqCommand manages the knowledge base:
+// /faq add <id> <question> | <answer> [| keyword, keyword] or /faq del <id>.
+func faqCommand(support *Support, cmd command) error {
+	action, rest := cutWord(cmd.args)
+	id, text := cutWord(rest)
+	if id == "" {
+		return fmt.Errorf("usage: /faq add <id> <question> | <answer> [| keyword, keyword] or /faq del <id>")
+	}
+
+	switch action {
+	case "add":
+		parts := strings.SplitN(text, faqSeparator, 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("separate the question and the answer with %s", faqSeparator)
+		}
+
+		var keywords []string
+		if len(parts) == 3 {
+			for _, keyword := range strings.Split(parts[2], ",") {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					keywords = append(keywords, keyword)
+				}
+			}
+		}
+
+		err := support.SaveArticle(cmd.msg.ChatID, entity.NewArticle(id, parts[0], parts[1], keywords))
+		if err != nil {
+			return err
+		}
+	case "del":
+		err := support.RemoveArticle(cmd.msg.ChatID, id)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action %s, use add or del", action)
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "Knowledge base updated", cmd.bot)
+	return nil
+}
+
+func faqsCommand(support *Support, cmd command) error {
+	articles, err := support.Articles(cmd.msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	if len(articles) == 0 {
+		support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "The knowledge base is empty", cmd.bot)
+		return nil
+	}
+
+	var text strings.Builder
+	text.WriteString("Knowledge base:")
+	for _, article := range articles {
+		fmt.Fprintf(&text, "\n%s: %s", article.ID, article.Question)
+		if len(article.Keywords) > 0 {
+			fmt.Fprintf(&text, " [%s]", strings.Join(article.Keywords, ", "))
+		}
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text.String(), cmd.bot)
+	return nil
+}
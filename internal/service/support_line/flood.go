@@ -0,0 +1,342 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	floodKey = "chatid{%d}:user:{%d}:flood"
+	floodMuteKey = "chatid{%d}:user:{%d}:muted"
+	floodLastKey = "chatid{%d}:user:{%d}:last"
+	floodAlertKey = "chatid{%d}:user:{%d}:flood:%s"
+	floodHeldKey = "chatid{%d}:user:{%d}:flood:held"
+	floodHeldLists = "flood:held"
+	floodHeldList = "%d:%d"
+	floodSlowDown = "slow-down"
+	floodDuplicate = "duplicate"
+	defaultFloodWindow = time.Minute
+)
+
+type floodReplyData struct {
+	Minutes int
+}
+
+// throttle holds back the messages of the flooding user and tells whether
+// the message must not be forwarded now. Duplicates are dropped.
+func (support *Support) throttle(telegramMessage entity.UserMessage, bot *bot.Bot) (bool, error) {
+	settings := support.settings.Tenant(telegramMessage.GroupChatID).Flood
+	ctx := context.Background()
+	chatID, userID := telegramMessage.GroupChatID, telegramMessage.UserID
+
+	if settings.MuteMinutes > 0 {
+		muted, err := support.db.UserState(ctx, fmt.Sprintf(floodMuteKey, chatID, userID))
+		if err != nil || muted != "" {
+			return muted != "", err
+		}
+	}
+
+	window := floodWindow(settings)
+
+	if settings.DuplicateSeconds > 0 {
+		duplicate, err := support.isDuplicate(telegramMessage, time.Duration(settings.DuplicateSeconds)*time.Second)
+		if err != nil {
+			return false, err
+		}
+
+		if duplicate {
+			if support.firstAlert(chatID, userID, floodDuplicate, window) {
+				support.replyFlood(telegramMessage, settings.Duplicate, 0, bot)
+				support.noticeUserTopic(chatID, userID, "🔁 The user repeats the same message, the copies are dropped", bot)
+			}
+			return true, nil
+		}
+	}
+
+	if settings.Limit <= 0 {
+		return false, nil
+	}
+
+	count, err := support.db.TrackMessage(
+		ctx,
+		fmt.Sprintf(floodKey, chatID, userID),
+		strconv.FormatInt(telegramMessage.MessageID, 10),
+		time.Now(),
+		window)
+	if err != nil {
+		return false, err
+	}
+
+	if settings.MuteAfter > 0 && settings.MuteMinutes > 0 && count >= int64(settings.MuteAfter) {
+		err = support.db.SetUserState(
+			ctx,
+			fmt.Sprintf(floodMuteKey, chatID, userID),
+			strconv.FormatInt(time.Now().Unix(), 10),
+			time.Duration(settings.MuteMinutes)*time.Minute)
+		if err != nil {
+			return true, err
+		}
+
+		err = support.db.DropHeldMessages(ctx, fmt.Sprintf(floodHeldKey, chatID, userID))
+		if err != nil {
+			support.log.Error("Can`t drop held messages of the muted user", "Error", err)
+		}
+
+		support.replyFlood(telegramMessage, settings.Muted, settings.MuteMinutes, bot)
+		support.noticeUserTopic(
+			chatID,
+			userID,
+			fmt.Sprintf("🔇 The user is muted for %d minutes for sending %d messages in %s, the held messages are dropped", settings.MuteMinutes, count, window),
+			bot)
+		return true, nil
+	}
+
+	held, err := support.db.QueueLength(ctx, fmt.Sprintf(floodHeldKey, chatID, userID))
+	if err != nil {
+		return false, err
+	}
+
+	// While some messages are held the new ones wait behind them, so the
+	// agents get them in the order the user sent them.
+	if held == 0 && count <= int64(settings.Limit) {
+		return false, nil
+	}
+
+	err = support.holdMessage(telegramMessage)
+	if err != nil {
+		return false, err
+	}
+
+	if support.firstAlert(chatID, userID, floodSlowDown, window) {
+		support.replyFlood(telegramMessage, settings.SlowDown, 0, bot)
+		support.noticeUserTopic(
+			chatID,
+			userID,
+			fmt.Sprintf("⏳ The user sends more than %d messages in %s, the extra ones are held back and delivered later", settings.Limit, window),
+			bot)
+	}
+
+	return true, nil
+}
+
+func floodWindow(settings config.FloodConfig) time.Duration {
+	if settings.WindowSeconds > 0 {
+		return time.Duration(settings.WindowSeconds) * time.Second
+	}
+	return defaultFloodWindow
+}
+
+func (support *Support) holdMessage(telegramMessage entity.UserMessage) error {
+	data, err := encoding.ToJSON(telegramMessage)
+	if err != nil {
+		return err
+	}
+
+	encryptMessage, err := crypto.EncryptData(data)
+	if err != nil {
+		return err
+	}
+
+	return support.db.HoldMessage(
+		context.Background(),
+		fmt.Sprintf(floodHeldKey, telegramMessage.GroupChatID, telegramMessage.UserID),
+		floodHeldLists,
+		fmt.Sprintf(floodHeldList, telegramMessage.GroupChatID, telegramMessage.UserID),
+		encryptMessage)
+}
+
+// releaseHeldMessagesFunc forwards the held messages as the flood window
+// of the user frees up.
+func (support *Support) releaseHeldMessagesFunc() func() {
+	// The runs must not overlap, both would forward the first held message.
+	var running atomic.Bool
+	return func() {
+		if !running.CompareAndSwap(false, true) {
+			return
+		}
+		defer running.Store(false)
+
+		lists, err := support.db.HeldLists(context.Background(), floodHeldLists)
+		if err != nil {
+			support.log.Error("Failed to get users with held messages", "Error", err)
+			return
+		}
+
+		bots := make(map[string]*bot.Bot)
+		defer func() {
+			for _, bot := range bots {
+				bot.Close()
+			}
+		}()
+
+		for _, list := range lists {
+			var chatID, userID int64
+			_, err := fmt.Sscanf(list, floodHeldList, &chatID, &userID)
+			if err != nil {
+				support.log.Error("Invalid held message list", "List", list, "Error", err)
+				continue
+			}
+			support.releaseHeldMessages(chatID, userID, list, bots)
+		}
+	}
+}
+
+// releaseHeldMessages forwards the held messages of the user while the
+// window has room for them. A released message takes its place in the
+// window, so no more than Limit messages a window reach the agents.
+func (support *Support) releaseHeldMessages(chatID, userID int64, list string, bots map[string]*bot.Bot) {
+	ctx := context.Background()
+	settings := support.settings.Tenant(chatID).Flood
+	window := floodWindow(settings)
+	windowKey := fmt.Sprintf(floodKey, chatID, userID)
+	heldKey := fmt.Sprintf(floodHeldKey, chatID, userID)
+
+	for {
+		now := time.Now()
+		if settings.Limit > 0 {
+			count, err := support.db.CountMessages(ctx, windowKey, now, window)
+			if err != nil {
+				support.log.Error("Failed to count user messages", "Error", err)
+				return
+			}
+
+			if count >= int64(settings.Limit) {
+				return
+			}
+		}
+
+		held, err := support.db.QueuedMessage(ctx, heldKey)
+		if err != nil {
+			support.log.Error("Failed to read held message", "Error", err)
+			return
+		}
+
+		if held == "" {
+			err = support.db.ReleaseHeldList(ctx, heldKey, floodHeldLists, list)
+			if err != nil {
+				support.log.Error("Failed to release held message list", "Error", err)
+			}
+			return
+		}
+
+		telegramMessage, err := decryptQueuedMessage(held)
+		if err != nil {
+			support.log.Error("Can`t parse held message, dropping it", "Error", err)
+			support.dequeueMessage(heldKey)
+			continue
+		}
+
+		if _, ok := bots[telegramMessage.BotToken]; !ok {
+			bot, err := bot.New(support.log, telegramMessage.BotToken, support.timeout)
+			if err != nil {
+				support.log.Error("Can`t initialize bot while release held message", "Error", err)
+				return
+			}
+			bots[telegramMessage.BotToken] = bot
+		}
+
+		err = support.forwardHeldMessage(telegramMessage, bots[telegramMessage.BotToken])
+		if err != nil {
+			support.log.Error("Failed to deliver held message, dropping it", "Error", err)
+		}
+
+		_, err = support.db.TrackMessage(
+			ctx,
+			windowKey,
+			"held:"+strconv.FormatInt(telegramMessage.MessageID, 10),
+			now,
+			window)
+		if err != nil {
+			support.log.Error("Can`t track released message", "Error", err)
+		}
+		support.dequeueMessage(heldKey)
+	}
+}
+
+func (support *Support) forwardHeldMessage(telegramMessage entity.UserMessage, bot *bot.Bot) error {
+	chatID, err := support.resolveChatID(telegramMessage.GroupChatID)
+	if err != nil {
+		return err
+	}
+	telegramMessage.GroupChatID = chatID
+
+	supportChat, err := bot.ChatByID(chatID)
+	if err != nil {
+		return err
+	}
+
+	return support.forwardUserMessage(telegramMessage, bot, supportChat)
+}
+
+// isDuplicate compares the message with the previous one of the user.
+// A retried delivery of the same message is not a duplicate.
+func (support *Support) isDuplicate(telegramMessage entity.UserMessage, ttl time.Duration) (bool, error) {
+	content := telegramMessage.Payload + "|" + telegramMessage.Caption + "|" + strings.Join(telegramMessage.FileIDs, ",")
+	if content == "||" {
+		return false, nil
+	}
+
+	hash := crypto.HashData(content)
+	previous, err := support.db.SwapUserState(
+		context.Background(),
+		fmt.Sprintf(floodLastKey, telegramMessage.GroupChatID, telegramMessage.UserID),
+		fmt.Sprintf("%s:%d", hash, telegramMessage.MessageID),
+		ttl)
+	if err != nil {
+		return false, err
+	}
+
+	previousHash, previousID, _ := strings.Cut(previous, ":")
+	return previousHash == hash && previousID != strconv.FormatInt(telegramMessage.MessageID, 10), nil
+}
+
+func (support *Support) firstAlert(chatID, userID int64, alert string, ttl time.Duration) bool {
+	first, err := support.db.SetAlert(
+		context.Background(),
+		fmt.Sprintf(floodAlertKey, chatID, userID, alert),
+		ttl)
+	if err != nil {
+		support.log.Error("Can`t set flood alert", "Error", err)
+	}
+	return first
+}
+
+func (support *Support) replyFlood(telegramMessage entity.UserMessage, templates map[string]string, minutes int, bot *bot.Bot) {
+	reply := localized(templates, telegramMessage.LanguageCode)
+	if reply == "" {
+		return
+	}
+
+	text, err := renderTemplate(reply, floodReplyData{Minutes: minutes})
+	if err != nil {
+		support.log.Error("Can`t render flood reply", "Error", err)
+		return
+	}
+
+	_, err = bot.Send(telebot.ChatID(telegramMessage.ChatID), text, &telebot.SendOptions{})
+	if err != nil {
+		support.log.Error("Can`t send flood reply", "Error", err)
+	}
+}
+
+// noticeUserTopic posts the notice to the topic of the user if there is one.
+func (support *Support) noticeUserTopic(chatID, userID int64, text string, bot *bot.Bot) {
+	topicData, err := support.topicByKey(fmt.Sprintf(topicUserKey, chatID, userID))
+	if err != nil {
+		return
+	}
+
+	support.notifyTopic(chatID, topicData.TopicID, text, bot)
+}
@@ -0,0 +1,172 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+const (
+	afterHoursReplyKey = "chatid{%d}:user:{%d}:afterhours"
+	holidayLayout = "2006-01-02"
+	nextOpenLayout = "Mon 02 Jan 15:04"
+	defaultAfterHoursReply = "We are offline right now. We will get back to you after {{.NextOpen}}."
+	maxClosedDays = 31
+	afterHoursNotice = "🌙 Received after hours"
+)
+
+type afterHoursData struct {
+	NextOpen string
+	ResponseTime string
+	Ticket int64
+}
+
+// afterHours reports whether the tenant is closed at the moment and when
+// it opens next. A zero time means the schedule has no working hours.
+func (support *Support) afterHours(chatID int64, now time.Time) (bool, time.Time) {
+	hours := support.settings.Tenant(chatID).BusinessHours
+	if !hours.Enabled {
+		return false, time.Time{}
+	}
+
+	week := support.businessWeek(chatID)
+	now = now.In(support.tenantLocation(chatID))
+
+	if isOpen(week, hours.Holidays, now) {
+		return false, time.Time{}
+	}
+
+	for offset := 0; offset <= maxClosedDays; offset++ {
+		day := now.AddDate(0, 0, offset)
+		if isHoliday(hours.Holidays, day) {
+			continue
+		}
+
+		shift, ok := week[day.Weekday()]
+		if !ok {
+			continue
+		}
+
+		if open := shift.StartOn(day); open.After(now) {
+			return true, open
+		}
+	}
+
+	return true, time.Time{}
+}
+
+func (support *Support) businessWeek(chatID int64) map[time.Weekday]entity.Shift {
+	week := make(map[time.Weekday]entity.Shift)
+	for day, window := range support.settings.Tenant(chatID).BusinessHours.Week {
+		start, end, _ := strings.Cut(window, "-")
+		shift, err := entity.NewShift([]string{day}, strings.TrimSpace(start), strings.TrimSpace(end))
+		if err != nil {
+			support.log.Error("Invalid business hours", "ChatID", chatID, "Day", day, "Error", err)
+			continue
+		}
+
+		weekday, _ := entity.Weekday(day)
+		week[weekday] = shift
+	}
+
+	return week
+}
+
+func (support *Support) markAfterHours(ticket *entity.Ticket, topicData entity.TopicData, bot *bot.Bot) {
+	updated, found, err := support.updateTicket(ticket.GroupChatID, ticket.Number, func(ticket *entity.Ticket) error {
+		ticket.AfterHours = true
+		return nil
+	})
+	if err != nil || !found {
+		support.log.Error("Can`t mark ticket as received after hours", "Error", err)
+		return
+	}
+	*ticket = updated
+
+	support.applyTicketState(*ticket, ticket.Status, topicData, bot)
+	support.notifyTopic(ticket.GroupChatID, ticket.TopicID, afterHoursNotice, bot)
+}
+
+// replyAfterHours answers the user at most once per closed period.
+func (support *Support) replyAfterHours(ticket entity.Ticket, nextOpen time.Time, bot *bot.Bot) {
+	ttl := time.Until(nextOpen)
+	if nextOpen.IsZero() || ttl < time.Minute {
+		ttl = 24 * time.Hour
+	}
+
+	isNew, err := support.db.SetAlert(
+		context.Background(),
+		fmt.Sprintf(afterHoursReplyKey, ticket.GroupChatID, ticket.UserID),
+		ttl)
+	if err != nil || !isNew {
+		return
+	}
+
+	hours := support.settings.Tenant(ticket.GroupChatID).BusinessHours
+	reply := hours.Reply
+	if reply == "" {
+		reply = defaultAfterHoursReply
+	}
+
+	data := afterHoursData{
+		ResponseTime: hours.ResponseTime,
+		Ticket: ticket.Number,
+	}
+	if !nextOpen.IsZero() {
+		data.NextOpen = nextOpen.Format(nextOpenLayout)
+	}
+
+	text, err := renderTemplate(reply, data)
+	if err != nil {
+		support.log.Error("Can`t render after hours reply", "Error", err)
+		return
+	}
+
+	_, err = bot.Send(telebot.ChatID(ticket.ChatID), text, &telebot.SendOptions{})
+	if err != nil {
+		support.log.Error("Can`t send after hours reply", "Error", err)
+	}
+}
+
+// isOpen checks the shift of the day and the overnight shift started the
+// day before, a holiday closes the shifts starting on it.
+func isOpen(week map[time.Weekday]entity.Shift, holidays []string, now time.Time) bool {
+	for _, day := range []time.Time{now, now.AddDate(0, 0, -1)} {
+		if isHoliday(holidays, day) {
+			continue
+		}
+
+		if shift, ok := week[day.Weekday()]; ok && shift.Covers(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHoliday(holidays []string, day time.Time) bool {
+	date := day.Format(holidayLayout)
+	for _, holiday := range holidays {
+		if holiday == date {
+			return true
+		}
+	}
+	return false
+}
+
+func renderTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("reply").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var res strings.Builder
+	err = tmpl.Execute(&res, data)
+	return res.String(), err
+}
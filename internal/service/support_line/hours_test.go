@@ -0,0 +1,59 @@
+package supportline
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/config"
+)
+
+func TestAfterHours(t *testing.T) {
+	support := &Support{
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		location: time.UTC,
+		settings: config.SupportConfig{Default: config.TenantConfig{
+			Timezone: "UTC",
+			BusinessHours: config.BusinessHoursConfig{
+				Enabled: true,
+				Week: map[string]string{
+					"mon": "09:00-18:00",
+					"tue": "09:00-18:00",
+					"fri": "22:00-02:00",
+				},
+				Holidays: []string{"2026-10-20"},
+			},
+		}},
+	}
+
+	// 2026-10-19 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		now time.Time
+		closed bool
+		nextOpen time.Time
+	}{
+		{"inside the shift", at(19, 12, 0), false, time.Time{}},
+		{"before the shift", at(19, 8, 0), true, at(19, 9, 0)},
+		{"after the shift skips the holiday", at(19, 19, 0), true, at(23, 22, 0)},
+		{"on the holiday", at(20, 12, 0), true, at(23, 22, 0)},
+		{"overnight shift before midnight", at(23, 23, 0), false, time.Time{}},
+		{"overnight shift after midnight", at(24, 1, 30), false, time.Time{}},
+		{"after the overnight shift", at(24, 2, 0), true, at(26, 9, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			closed, nextOpen := support.afterHours(0, test.now)
+			if closed != test.closed || !nextOpen.Equal(test.nextOpen) {
+				t.Errorf("afterHours(%s) = %v, %s, want %v, %s",
+					test.now.Format("Mon 02 15:04"), closed, nextOpen, test.closed, test.nextOpen)
+			}
+		})
+	}
+}
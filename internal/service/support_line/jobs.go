@@ -0,0 +1,75 @@
+package supportline
+
+import (
+	"context"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+type topicTicket struct {
+	topic entity.TopicData
+	ticket entity.Ticket
+}
+
+// botPool shares bot clients between the topics handled by a scheduled job.
+type botPool struct {
+	support *Support
+	bots map[string]*bot.Bot
+}
+
+func (support *Support) newBotPool() *botPool {
+	return &botPool{
+		support: support,
+		bots: make(map[string]*bot.Bot),
+	}
+}
+
+func (pool *botPool) get(token string) (*bot.Bot, error) {
+	if client, ok := pool.bots[token]; ok {
+		return client, nil
+	}
+
+	client, err := bot.NewWithoutDecryption(pool.support.log, token, pool.support.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.bots[token] = client
+	return client, nil
+}
+
+func (pool *botPool) close() {
+	for _, client := range pool.bots {
+		client.Close()
+	}
+}
+
+// topicTickets loads the tickets of every topic that exists in the support chats.
+func (support *Support) topicTickets() ([]topicTicket, error) {
+	keys, err := support.db.AllTopics(context.Background(), allTopics)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets := make([]topicTicket, 0, len(keys))
+	for _, key := range keys {
+		topicData, err := support.topicByKey(key)
+		if err != nil {
+			support.log.Error("Can`t read topic data", "Key", key, "Error", err)
+			continue
+		}
+
+		ticket, found, err := support.ticket(topicData.GroupChatID, topicData.Ticket)
+		if err != nil {
+			support.log.Error("Can`t read ticket", "Ticket", topicData.Ticket, "Error", err)
+			continue
+		}
+
+		if found {
+			tickets = append(tickets, topicTicket{topic: topicData, ticket: ticket})
+		}
+	}
+
+	return tickets, nil
+}
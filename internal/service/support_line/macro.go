@@ -0,0 +1,251 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	macrosKey = "chatid{%d}:macros"
+	macroStatusPrefix = "status="
+)
+
+var macroName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+type macroData struct {
+	UserName string
+	FirstName string
+	LastName string
+	Ticket int64
+	Agent string
+}
+
+func(support *Support) Macros(chatID int64) ([]entity.Macro, error) {
+	records, err := support.db.Macros(context.Background(), fmt.Sprintf(macrosKey, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	macros := make([]entity.Macro, 0, len(records))
+	for _, record := range records {
+		macro, err := entity.NewMacroFromJSON([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		macros = append(macros, macro)
+	}
+
+	sort.Slice(macros, func(i, j int) bool {
+		return macros[i].Name < macros[j].Name
+	})
+
+	return macros, nil
+}
+
+func(support *Support) SaveMacro(chatID int64, macro entity.Macro) error {
+	macro.Name = strings.ToLower(macro.Name)
+	if !macroName.MatchString(macro.Name) {
+		return fmt.Errorf("invalid macro name %s, use up to 32 latin letters, digits, - or _", macro.Name)
+	}
+
+	if strings.TrimSpace(macro.Text) == "" {
+		return fmt.Errorf("macro %s has no text", macro.Name)
+	}
+
+	if macro.Status != "" && !entity.IsStatus(macro.Status) {
+		return fmt.Errorf("unknown ticket status %s", macro.Status)
+	}
+
+	if _, err := renderTemplate(macro.Text, macroData{}); err != nil {
+		return fmt.Errorf("invalid macro template: %w", err)
+	}
+
+	data, err := encoding.ToJSON(macro)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetMacro(
+		context.Background(),
+		fmt.Sprintf(macrosKey, chatID),
+		macro.Name,
+		string(data))
+}
+
+func(support *Support) RemoveMacro(chatID int64, name string) error {
+	return support.db.RemoveMacro(
+		context.Background(),
+		fmt.Sprintf(macrosKey, chatID),
+		strings.ToLower(name))
+}
+
+func (support *Support) macro(chatID int64, name string) (entity.Macro, bool, error) {
+	record, err := support.db.Macro(
+		context.Background(),
+		fmt.Sprintf(macrosKey, chatID),
+		strings.ToLower(name))
+	if err != nil || record == "" {
+		return entity.Macro{}, false, err
+	}
+
+	macro, err := entity.NewMacroFromJSON([]byte(record))
+	return macro, err == nil, err
+}
+
+func (support *Support) expandMacro(macro entity.Macro, topicData entity.TopicData, agent entity.Agent) (string, error) {
+	profile, _, err := support.profile(topicData.GroupChatID, topicData.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := support.macroAgent(topicData.GroupChatID, agent)
+	if err != nil {
+		return "", err
+	}
+
+	return renderTemplate(macro.Text, macroData{
+		UserName: profile.UserName,
+		FirstName: profile.FirstName,
+		LastName: profile.LastName,
+		Ticket: topicData.Ticket,
+		Agent: name,
+	})
+}
+
+// macroAgent is the agent name for the macro. Anonymous tenants never show
+// the agent, the macro gets the tenant persona or nothing.
+func (support *Support) macroAgent(chatID int64, agent entity.Agent) (string, error) {
+	name, err := support.agentAlias(chatID, agent)
+	if err != nil || name != "" {
+		return name, err
+	}
+
+	return support.settings.Tenant(chatID).Signature.Persona, nil
+}
+
+// macroCommand sends the expanded macro to the user: /m <name>.
+func macroCommand(support *Support, cmd command) error {
+	name := strings.TrimSpace(cmd.args)
+	if name == "" {
+		return fmt.Errorf("usage: /m <name>")
+	}
+
+	macro, found, err := support.macro(cmd.msg.ChatID, name)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("macro %s not found, see /macros", name)
+	}
+
+	return support.sendMacro(cmd.msg, cmd.topic, macro, cmd.bot)
+}
+
+// sendMacro replies to the user with the expanded macro and applies its
+// ticket status.
+func (support *Support) sendMacro(supportMsg entity.SupportMessage, topicData entity.TopicData, macro entity.Macro, bot *bot.Bot) error {
+	text, err := support.expandMacro(macro, topicData, supportMsg.Sender())
+	if err != nil {
+		return err
+	}
+
+	err = support.replyToUser(supportMsg, topicData, text, bot)
+	if err != nil {
+		return err
+	}
+
+	if macro.Status == "" {
+		return nil
+	}
+
+	ticket, err := support.topicTicket(topicData)
+	if err != nil || ticket.Status == macro.Status {
+		return err
+	}
+
+	ticket, err = support.changeStatus(topicData, macro.Status, bot)
+	if err != nil {
+		return err
+	}
+
+	support.notifyTopic(supportMsg.ChatID, supportMsg.TopicID, fmt.Sprintf("Ticket #%d is %s", ticket.Number, ticket.Status), bot)
+	return nil
+}
+
+// macroAdminCommand manages the macros:
+// /macro add <name> [status=<status>] <text> or /macro del <name>.
+func macroAdminCommand(support *Support, cmd command) error {
+	action, rest := cutWord(cmd.args)
+	name, text := cutWord(rest)
+	if name == "" {
+		return fmt.Errorf("usage: /macro add <name> [status=<status>] <text> or /macro del <name>")
+	}
+
+	switch action {
+	case "add":
+		status := ""
+		if first, other := cutWord(text); strings.HasPrefix(first, macroStatusPrefix) {
+			status = strings.TrimPrefix(first, macroStatusPrefix)
+			text = other
+		}
+
+		err := support.SaveMacro(cmd.msg.ChatID, entity.NewMacro(name, text, status))
+		if err != nil {
+			return err
+		}
+	case "del":
+		err := support.RemoveMacro(cmd.msg.ChatID, name)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action %s, use add or del", action)
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "Macros updated", cmd.bot)
+	return nil
+}
+
+func macrosCommand(support *Support, cmd command) error {
+	macros, err := support.Macros(cmd.msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	if len(macros) == 0 {
+		support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "There are no macros yet", cmd.bot)
+		return nil
+	}
+
+	var text strings.Builder
+	text.WriteString("Macros:")
+	for _, macro := range macros {
+		fmt.Fprintf(&text, "\n/m %s", macro.Name)
+		if macro.Status != "" {
+			fmt.Fprintf(&text, " (→ %s)", macro.Status)
+		}
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text.String(), cmd.bot)
+	return nil
+}
+
+// cutWord splits off the first word, keeping the line breaks of the rest.
+func cutWord(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return text, ""
+	}
+
+	return text[:end], strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+}
@@ -0,0 +1,37 @@
+package supportline
+
+import (
+	"testing"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+func TestAnonymousMacroHidesAgent(t *testing.T) {
+	agent := entity.NewAgent(42, "@jane_doe", "Jane Doe")
+	support := &Support{settings: config.SupportConfig{Default: config.TenantConfig{
+		Signature: config.SignatureConfig{Mode: signatureAnonymous},
+	}}}
+
+	name, err := support.macroAgent(-100, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := renderTemplate("Regards, {{.Agent}}", macroData{Agent: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Regards, " {
+		t.Errorf("anonymous macro is %q, want the agent left out", text)
+	}
+
+	support.settings.Default.Signature.Persona = "Support team"
+	name, err = support.macroAgent(-100, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Support team" {
+		t.Errorf("anonymous macro agent is %q, want the tenant persona", name)
+	}
+}
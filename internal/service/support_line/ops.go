@@ -0,0 +1,20 @@
+package supportline
+
+import (
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+func (support *Support) alertOps(chatID int64, text string, bot *bot.Bot) {
+	opsChatID := support.settings.Tenant(chatID).OpsChatID
+	if opsChatID == 0 {
+		support.log.Warn("Ops chat is not configured, alert skipped", "ChatID", chatID, "Alert", text)
+		return
+	}
+
+	_, err := bot.Send(telebot.ChatID(opsChatID), text, &telebot.SendOptions{})
+	if err != nil {
+		support.log.Error("Can`t send alert to the ops chat", "Error", err)
+	}
+}
@@ -0,0 +1,440 @@
+package supportline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	chatKeyPrefix = "chatid{%d}:"
+	chatKeysPattern = "chatid{%d}:*"
+	chatMigrationKey = "chatid{%d}:migrated"
+	chatAliasKey = "chatid{%d}:alias"
+	chatAliasPattern = "chatid{*}:alias"
+	chatQueueKey = "chatid{%d}:queue"
+	queuedChats = "queue:chats"
+	permissionAlertKey = "chatid{%d}:alert:permission"
+	permissionAlertTTL = time.Hour
+	maxMigrationHops = 5
+)
+
+var (
+	topicDataKey = regexp.MustCompile(`^chatid\{-?\d+\}:topic:(user:)?\{\d+\}$`)
+	ticketDataKey = regexp.MustCompile(`^chatid\{-?\d+\}:ticket:\{\d+\}$`)
+)
+
+// deliverUserMessage sends the user message to the support chat. It follows
+// the support group migration to a supergroup and holds messages back
+// in the queue while the bot can't manage topics there.
+func (support *Support) deliverUserMessage(telegramMessage entity.UserMessage, bot *bot.Bot) error {
+	chatID, err := support.resolveChatID(telegramMessage.GroupChatID)
+	if err != nil {
+		return err
+	}
+	telegramMessage.GroupChatID = chatID
+
+	queued, err := support.db.QueueLength(context.Background(), fmt.Sprintf(chatQueueKey, chatID))
+	if err != nil {
+		return err
+	}
+
+	if queued > 0 {
+		return support.enqueueMessage(telegramMessage)
+	}
+
+	err = support.sendToSupportChat(telegramMessage, bot)
+
+	var groupErr telebot.GroupError
+	if errors.As(err, &groupErr) {
+		err = support.migrateChat(chatID, groupErr.MigratedTo, bot)
+		if err != nil {
+			return err
+		}
+
+		telegramMessage.GroupChatID = groupErr.MigratedTo
+		err = support.sendToSupportChat(telegramMessage, bot)
+	}
+
+	if isPermissionLost(err) {
+		support.alertPermissionLost(telegramMessage.GroupChatID, err, bot)
+		return support.enqueueMessage(telegramMessage)
+	}
+
+	return err
+}
+
+func (support *Support) sendToSupportChat(telegramMessage entity.UserMessage, bot *bot.Bot) error {
+	supportChat, err := bot.ChatByID(telegramMessage.GroupChatID)
+	if err != nil {
+		return err
+	}
+
+	return support.handleUserMessage(telegramMessage, bot, supportChat)
+}
+
+func (support *Support) resolveChatID(chatID int64) (int64, error) {
+	for i := 0; i < maxMigrationHops; i++ {
+		migratedTo, err := support.db.ChatMigration(
+			context.Background(),
+			fmt.Sprintf(chatMigrationKey, chatID))
+		if err != nil {
+			return 0, err
+		}
+
+		if migratedTo == 0 {
+			break
+		}
+		chatID = migratedTo
+	}
+
+	return chatID, nil
+}
+
+// migrateChat moves every stored key of the tenant to the new chat ID in
+// one transaction and rewrites the chat ID kept inside the topics and the
+// tickets. The new chat keeps the alias of the configured one, so it goes on
+// with the tenant settings.
+func (support *Support) migrateChat(oldChatID, newChatID int64, bot *bot.Bot) error {
+	ctx := context.Background()
+	support.log.Info("Support chat was migrated", "From", oldChatID, "To", newChatID)
+
+	keys, err := support.db.Keys(ctx, fmt.Sprintf(chatKeysPattern, oldChatID))
+	if err != nil {
+		return err
+	}
+
+	listed, err := support.db.AllTopics(ctx, allTopics)
+	if err != nil {
+		return err
+	}
+
+	inList := make(map[string]bool, len(listed))
+	for _, key := range listed {
+		inList[key] = true
+	}
+
+	oldPrefix := fmt.Sprintf(chatKeyPrefix, oldChatID)
+	newPrefix := fmt.Sprintf(chatKeyPrefix, newChatID)
+	migrationKey := fmt.Sprintf(chatMigrationKey, oldChatID)
+	aliasKey := fmt.Sprintf(chatAliasKey, oldChatID)
+	configuredChatID := oldChatID
+
+	moves := make([]entity.KeyMove, 0, len(keys)+2)
+	for _, key := range keys {
+		if key == migrationKey {
+			continue
+		}
+
+		move := entity.KeyMove{
+			From: key,
+			To: newPrefix + strings.TrimPrefix(key, oldPrefix),
+			Listed: inList[key],
+		}
+
+		switch {
+		case key == aliasKey:
+			configuredChatID, err = support.db.ChatMigration(ctx, key)
+			if err != nil {
+				return err
+			}
+			continue
+		case topicDataKey.MatchString(key):
+			move.Value, err = support.migratedTopic(key, newChatID)
+		case ticketDataKey.MatchString(key):
+			move.Value, err = support.migratedTicket(key, newChatID)
+		}
+
+		if err != nil {
+			return err
+		}
+		moves = append(moves, move)
+	}
+
+	moves = append(moves,
+		entity.KeyMove{To: fmt.Sprintf(chatAliasKey, newChatID), Value: strconv.FormatInt(configuredChatID, 10)},
+		entity.KeyMove{To: migrationKey, Value: strconv.FormatInt(newChatID, 10)})
+
+	err = support.db.MigrateChat(ctx, moves, allTopics)
+	if err != nil {
+		support.alertOps(
+			oldChatID,
+			fmt.Sprintf("🚨 Moving the support chat %d to %d failed: %s. It is retried with the next message.", oldChatID, newChatID, err),
+			bot)
+		return err
+	}
+	support.settings.AddAlias(newChatID, configuredChatID)
+
+	queued, err := support.db.QueueLength(ctx, fmt.Sprintf(chatQueueKey, newChatID))
+	if err != nil {
+		return err
+	}
+
+	if queued > 0 {
+		err = support.db.AddQueuedChat(ctx, queuedChats, newChatID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return support.db.RemoveQueuedChat(ctx, queuedChats, oldChatID)
+}
+
+// loadChatAliases restores the aliases of the migrated chats.
+func (support *Support) loadChatAliases() {
+	ctx := context.Background()
+	keys, err := support.db.Keys(ctx, chatAliasPattern)
+	if err != nil {
+		support.log.Error("Can`t load chat aliases", "Error", err)
+		return
+	}
+
+	for _, key := range keys {
+		var chatID int64
+		_, err = fmt.Sscanf(key, chatAliasKey, &chatID)
+		if err != nil {
+			support.log.Error("Invalid chat alias key", "Key", key, "Error", err)
+			continue
+		}
+
+		configuredChatID, err := support.db.ChatMigration(ctx, key)
+		if err != nil {
+			support.log.Error("Can`t load chat alias", "Key", key, "Error", err)
+			continue
+		}
+
+		support.settings.AddAlias(chatID, configuredChatID)
+	}
+}
+
+func (support *Support) migratedTopic(key string, newChatID int64) (string, error) {
+	topic, err := support.db.Topic(context.Background(), key)
+	if err != nil || topic == "" {
+		return "", err
+	}
+
+	jsonTopic, err := crypto.DecryptData(topic)
+	if err != nil {
+		return "", err
+	}
+
+	topicData, err := entity.NewTopicFromJSON([]byte(jsonTopic))
+	if err != nil {
+		return "", err
+	}
+	topicData.GroupChatID = newChatID
+
+	data, err := encoding.ToJSON(topicData)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.EncryptData(data)
+}
+
+func (support *Support) migratedTicket(key string, newChatID int64) (string, error) {
+	data, err := support.db.Ticket(context.Background(), key)
+	if err != nil || data == "" {
+		return "", err
+	}
+
+	jsonTicket, err := crypto.DecryptData(data)
+	if err != nil {
+		return "", err
+	}
+
+	ticket, err := entity.NewTicketFromJSON([]byte(jsonTicket))
+	if err != nil {
+		return "", err
+	}
+	ticket.GroupChatID = newChatID
+
+	ticketData, err := encoding.ToJSON(ticket)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.EncryptData(ticketData)
+}
+
+func (support *Support) enqueueMessage(telegramMessage entity.UserMessage) error {
+	data, err := encoding.ToJSON(telegramMessage)
+	if err != nil {
+		return err
+	}
+
+	encryptMessage, err := crypto.EncryptData(data)
+	if err != nil {
+		return err
+	}
+
+	return support.db.EnqueueMessage(
+		context.Background(),
+		fmt.Sprintf(chatQueueKey, telegramMessage.GroupChatID),
+		queuedChats,
+		telegramMessage.GroupChatID,
+		encryptMessage)
+}
+
+func (support *Support) alertPermissionLost(chatID int64, cause error, bot *bot.Bot) {
+	isNew, err := support.db.SetAlert(
+		context.Background(),
+		fmt.Sprintf(permissionAlertKey, chatID),
+		permissionAlertTTL)
+	if err != nil {
+		support.log.Error("Can`t save permission alert", "Error", err)
+		return
+	}
+
+	if !isNew {
+		return
+	}
+
+	support.alertOps(
+		chatID,
+		fmt.Sprintf("🚨 The bot can't manage topics in the support chat %d: %s. User messages are queued until the rights are restored.", chatID, cause),
+		bot)
+}
+
+func (support *Support) retryQueuedMessagesFunc() func() {
+	return func() {
+		chats, err := support.db.QueuedChats(context.Background(), queuedChats)
+		if err != nil {
+			support.log.Error("Failed to get chats with queued messages", "Error", err)
+			return
+		}
+
+		for _, chat := range chats {
+			chatID, err := strconv.ParseInt(chat, 10, 64)
+			if err != nil {
+				support.log.Error("Invalid chat in the message queue", "Error", err)
+				continue
+			}
+			support.drainQueue(chatID)
+		}
+	}
+}
+
+func (support *Support) drainQueue(chatID int64) {
+	ctx := context.Background()
+	queueKey := fmt.Sprintf(chatQueueKey, chatID)
+	bots := make(map[string]*bot.Bot)
+	defer func() {
+		for _, bot := range bots {
+			bot.Close()
+		}
+	}()
+
+	delivered := 0
+	for {
+		queued, err := support.db.QueuedMessage(ctx, queueKey)
+		if err != nil {
+			support.log.Error("Failed to read queued message", "Error", err)
+			return
+		}
+
+		if queued == "" {
+			break
+		}
+
+		telegramMessage, err := decryptQueuedMessage(queued)
+		if err != nil {
+			support.log.Error("Can`t parse queued message, dropping it", "Error", err)
+			support.dequeueMessage(queueKey)
+			continue
+		}
+		telegramMessage.GroupChatID = chatID
+
+		if _, ok := bots[telegramMessage.BotToken]; !ok {
+			bot, err := bot.New(support.log, telegramMessage.BotToken, support.timeout)
+			if err != nil {
+				support.log.Error("Can`t initialize bot while process queued message", "Error", err)
+				return
+			}
+			bots[telegramMessage.BotToken] = bot
+		}
+		bot := bots[telegramMessage.BotToken]
+
+		err = support.sendToSupportChat(telegramMessage, bot)
+		if isPermissionLost(err) {
+			return
+		}
+
+		var groupErr telebot.GroupError
+		if errors.As(err, &groupErr) {
+			err = support.migrateChat(chatID, groupErr.MigratedTo, bot)
+			if err != nil {
+				support.log.Error("Failed to migrate support chat", "Error", err)
+			}
+			return
+		}
+
+		if err != nil {
+			support.log.Error("Failed to deliver queued message, dropping it", "Error", err)
+		} else {
+			delivered++
+		}
+		support.dequeueMessage(queueKey)
+	}
+
+	err := support.db.RemoveQueuedChat(ctx, queuedChats, chatID)
+	if err != nil {
+		support.log.Error("Failed to remove chat from the message queue", "Error", err)
+	}
+
+	err = support.db.ClearAlert(ctx, fmt.Sprintf(permissionAlertKey, chatID))
+	if err != nil {
+		support.log.Error("Failed to clear permission alert", "Error", err)
+	}
+
+	for _, bot := range bots {
+		support.alertOps(
+			chatID,
+			fmt.Sprintf("✅ The bot manages topics in the support chat %d again. Queued messages delivered: %d.", chatID, delivered),
+			bot)
+		break
+	}
+}
+
+func (support *Support) dequeueMessage(queueKey string) {
+	err := support.db.DequeueMessage(context.Background(), queueKey)
+	if err != nil {
+		support.log.Error("Failed to remove queued message", "Error", err)
+	}
+}
+
+func decryptQueuedMessage(queued string) (entity.UserMessage, error) {
+	jsonMessage, err := crypto.DecryptData(queued)
+	if err != nil {
+		return entity.UserMessage{}, err
+	}
+
+	return entity.NewUserMessageFromJSON([]byte(jsonMessage))
+}
+
+func isPermissionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, telebot.ErrKickedFromGroup) ||
+		errors.Is(err, telebot.ErrKickedFromSuperGroup) ||
+		errors.Is(err, telebot.ErrNoRightsToSend) {
+		return true
+	}
+
+	description := err.Error()
+	return strings.Contains(description, "not enough rights") ||
+		strings.Contains(description, "CHAT_ADMIN_REQUIRED")
+}
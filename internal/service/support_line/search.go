@@ -0,0 +1,280 @@
+package supportline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/export"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+)
+
+const (
+	searchTermKey = "chatid{%d}:search:{%s}"
+	defaultSearchLimit = 20
+	snippetLength = 100
+)
+
+// ErrEmptySearch is returned for the search text without words.
+var ErrEmptySearch = errors.New("the search text is empty")
+
+// Search finds the tickets of the tenant by the words of their transcripts
+// and metadata. The index keeps only the keyed hashes of the words.
+func(support *Support) Search(chatID int64, query entity.SearchQuery) ([]entity.SearchResult, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	keys := make([]string, 0, len(terms))
+	for _, term := range terms {
+		keys = append(keys, searchKey(chatID, term))
+	}
+
+	var since time.Time
+	if retention := support.searchRetention(chatID); retention > 0 {
+		since = time.Now().Add(-retention)
+	}
+
+	records, err := support.db.SearchTerms(context.Background(), since, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int64, 0, len(records))
+	for _, record := range records {
+		number, err := strconv.ParseInt(record, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] > numbers[j]
+	})
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	var results []entity.SearchResult
+	for _, number := range numbers {
+		if len(results) == limit {
+			break
+		}
+
+		ticket, found, err := support.ticket(chatID, number)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found || !matchTicket(ticket, query) {
+			continue
+		}
+
+		profile, _, err := support.profile(chatID, ticket.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !matchUser(ticket, profile, query.User) {
+			continue
+		}
+
+		results = append(results, entity.SearchResult{
+			Ticket: ticket.Number,
+			Status: ticket.Status,
+			UserID: ticket.UserID,
+			UserName: profile.UserName,
+			CreatedAt: ticket.CreatedAt,
+			Link: support.ticketLink(ticket),
+			Snippet: support.snippet(ticket, terms),
+		})
+	}
+
+	return results, nil
+}
+
+// indexText adds the words of the texts to the search index of the ticket.
+// The words drop out of the index the transcript retention after they were
+// indexed, the same as the history entries.
+func (support *Support) indexText(chatID, number int64, texts ...string) {
+	terms := searchTerms(strings.Join(texts, " "))
+	if len(terms) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(terms))
+	for _, term := range terms {
+		keys = append(keys, searchKey(chatID, term))
+	}
+
+	err := support.db.IndexTerms(context.Background(), keys, number, time.Now(), support.searchRetention(chatID))
+	if err != nil {
+		support.log.Error("Can`t index the ticket text", "Ticket", number, "Error", err)
+	}
+}
+
+func (support *Support) searchRetention(chatID int64) time.Duration {
+	return time.Duration(support.settings.Tenant(chatID).Transcript.RetentionDays) * 24 * time.Hour
+}
+
+// ticketLink points to the topic while it exists and to the archive after.
+func (support *Support) ticketLink(ticket entity.Ticket) string {
+	topicData, err := support.topicByKey(fmt.Sprintf(topicSupportKey, ticket.GroupChatID, ticket.TopicID))
+	if err == nil && topicData.Ticket == ticket.Number {
+		return topicLink(ticket.GroupChatID, ticket.TopicID)
+	}
+
+	return ticket.Archive
+}
+
+func (support *Support) snippet(ticket entity.Ticket, terms []string) string {
+	entries, err := support.History(ticket.GroupChatID, ticket.Number)
+	if err != nil {
+		support.log.Error("Can`t load the ticket history", "Ticket", ticket.Number, "Error", err)
+		return ""
+	}
+
+	for _, entry := range entries {
+		text := strings.ToLower(entry.Text)
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				snippet := []rune(strings.Join(strings.Fields(entry.Text), " "))
+				if len(snippet) > snippetLength {
+					snippet = append(snippet[:snippetLength-1], '…')
+				}
+				return string(snippet)
+			}
+		}
+	}
+
+	return ""
+}
+
+func matchTicket(ticket entity.Ticket, query entity.SearchQuery) bool {
+	if query.Status != "" && ticket.Status != query.Status {
+		return false
+	}
+
+	if !query.From.IsZero() && ticket.CreatedAt < query.From.Unix() {
+		return false
+	}
+
+	if !query.To.IsZero() && ticket.CreatedAt > query.To.Unix() {
+		return false
+	}
+
+	return true
+}
+
+func matchUser(ticket entity.Ticket, profile entity.UserProfile, user string) bool {
+	if user == "" {
+		return true
+	}
+
+	if userID, err := strconv.ParseInt(user, 10, 64); err == nil {
+		return ticket.UserID == userID
+	}
+
+	return strings.EqualFold(profile.UserName, strings.TrimPrefix(user, "@"))
+}
+
+// topicLink builds the t.me link to the topic of the supergroup.
+func topicLink(chatID int64, topicID int) string {
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(strconv.FormatInt(chatID, 10), "-100"), topicID)
+}
+
+func searchKey(chatID int64, term string) string {
+	return fmt.Sprintf(searchTermKey, chatID, crypto.HashData(term))
+}
+
+// searchTerms splits the text into unique lower-cased words of two or
+// more letters or digits.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(words))
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if len([]rune(word)) < 2 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+
+	return terms
+}
+
+// searchCommand finds tickets: /search <words> [status=<status>]
+// [user=<id|@username>] [from=YYYY-MM-DD] [to=YYYY-MM-DD].
+func searchCommand(support *Support, cmd command) error {
+	var query entity.SearchQuery
+	var words []string
+	var from, to string
+	for _, field := range strings.Fields(cmd.args) {
+		name, value, found := strings.Cut(field, "=")
+		switch {
+		case found && name == "status":
+			query.Status = value
+		case found && name == "user":
+			query.User = value
+		case found && name == "from":
+			from = value
+		case found && name == "to":
+			to = value
+		default:
+			words = append(words, field)
+		}
+	}
+	query.Text = strings.Join(words, " ")
+	if query.Text == "" {
+		return fmt.Errorf("usage: /search <words> [status=<status>] [user=<id|@username>] [from=YYYY-MM-DD] [to=YYYY-MM-DD]")
+	}
+
+	if from != "" || to != "" {
+		var err error
+		query.From, query.To, err = export.ParsePeriod(from, to)
+		if err != nil {
+			return err
+		}
+	}
+
+	results, err := support.Search(cmd.msg.ChatID, query)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, "Nothing found", cmd.bot)
+		return nil
+	}
+
+	var text strings.Builder
+	text.WriteString("Found:")
+	for _, result := range results {
+		fmt.Fprintf(&text, "\n#%d %s", result.Ticket, result.Status)
+		if result.UserName != "" {
+			fmt.Fprintf(&text, " @%s", result.UserName)
+		}
+		if result.Link != "" {
+			fmt.Fprintf(&text, " %s", result.Link)
+		}
+		if result.Snippet != "" {
+			fmt.Fprintf(&text, "\n    %s", result.Snippet)
+		}
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text.String(), cmd.bot)
+	return nil
+}
@@ -0,0 +1,30 @@
+package supportline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"words", "Refund my order", []string{"refund", "my", "order"}},
+		{"punctuation", "order #12345, please!", []string{"order", "12345", "please"}},
+		{"duplicates", "Order order ORDER", []string{"order"}},
+		{"short words", "a b to go", []string{"to", "go"}},
+		{"cyrillic", "Возврат заказа", []string{"возврат", "заказа"}},
+		{"email", "user@example.com", []string{"user", "example", "com"}},
+		{"empty", "  !? ", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := searchTerms(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("searchTerms(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
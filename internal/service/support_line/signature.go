@@ -0,0 +1,79 @@
+package supportline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+const (
+	signatureAnonymous = "anonymous"
+	signatureName = "signature"
+	signaturePersona = "persona"
+	defaultSignatureTemplate = "{{.Text}}\n\n— {{.Name}}"
+)
+
+type signatureData struct {
+	Text string
+	Name string
+}
+
+// agentAlias is the name the user sees for the agent, empty when the
+// tenant answers anonymously.
+func (support *Support) agentAlias(chatID int64, sender entity.Agent) (string, error) {
+	settings := support.settings.Tenant(chatID).Signature
+	if settings.Mode == "" || settings.Mode == signatureAnonymous {
+		return "", nil
+	}
+
+	agent, found, err := support.rosterAgent(chatID, sender.Key())
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		agent = sender
+	}
+
+	switch settings.Mode {
+	case signatureName:
+		return agent.DisplayName(), nil
+	case signaturePersona:
+		if agent.Persona != "" {
+			return agent.Persona, nil
+		}
+		return settings.Persona, nil
+	default:
+		return "", fmt.Errorf("unknown signature mode %s", settings.Mode)
+	}
+}
+
+// sign appends the agent signature to the reply according to the tenant mode.
+func (support *Support) sign(supportMsg entity.SupportMessage, text string) (string, error) {
+	name, err := support.agentAlias(supportMsg.ChatID, supportMsg.Sender())
+	if err != nil || name == "" {
+		return text, err
+	}
+
+	tmpl := support.settings.Tenant(supportMsg.ChatID).Signature.Template
+	if tmpl == "" {
+		tmpl = defaultSignatureTemplate
+	}
+
+	return renderTemplate(tmpl, signatureData{Text: text, Name: name})
+}
+
+func (support *Support) setPersona(chatID int64, userName, persona string) error {
+	agent, found, err := support.rosterAgent(chatID, userName)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("%s is not in the roster, add them with /agent add %s", userName, userName)
+	}
+
+	agent.Persona = strings.TrimSpace(persona)
+	return support.saveAgent(chatID, agent)
+}
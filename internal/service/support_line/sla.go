@@ -0,0 +1,255 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+)
+
+const (
+	ticketRepliesKey = "chatid{%d}:ticket:{%d}:replies"
+	slaFirstResponse = "first response"
+	slaResolution = "resolution"
+	slaWarning = "warning"
+	slaBreach = "breach"
+	defaultSLAWarnPercent = 80
+)
+
+type slaMetric struct {
+	name string
+	elapsed time.Duration
+	target time.Duration
+}
+
+type slaAlert struct {
+	metric slaMetric
+	level string
+}
+
+func (alert slaAlert) name() string {
+	return alert.metric.name + " " + alert.level
+}
+
+func (support *Support) slaTarget(ticket entity.Ticket) (config.SLATarget, bool) {
+	targets := support.settings.Tenant(ticket.GroupChatID).SLA.Targets
+	if target, ok := targets[ticket.Priority]; ok {
+		return target, true
+	}
+
+	target, ok := targets[entity.PriorityNormal]
+	return target, ok
+}
+
+// pendingSLAMetrics returns the targets the ticket still has to meet.
+func (support *Support) pendingSLAMetrics(ticket entity.Ticket, now time.Time) []slaMetric {
+	target, ok := support.slaTarget(ticket)
+	if !ok || !ticket.Active() {
+		return nil
+	}
+
+	elapsed := now.Sub(time.Unix(ticket.FirstMessageAt, 0))
+	var metrics []slaMetric
+	if ticket.FirstResponseAt == 0 && target.FirstResponseMinutes > 0 {
+		metrics = append(metrics, slaMetric{
+			name: slaFirstResponse,
+			elapsed: elapsed,
+			target: time.Duration(target.FirstResponseMinutes) * time.Minute,
+		})
+	}
+
+	if target.ResolutionMinutes > 0 {
+		metrics = append(metrics, slaMetric{
+			name: slaResolution,
+			elapsed: elapsed,
+			target: time.Duration(target.ResolutionMinutes) * time.Minute,
+		})
+	}
+
+	return metrics
+}
+
+func (support *Support) checkSLAFunc() func() {
+	return func() {
+		tickets, err := support.topicTickets()
+		if err != nil {
+			support.log.Error("Failed to get tickets for SLA check", "Error", err)
+			return
+		}
+
+		pool := support.newBotPool()
+		defer pool.close()
+
+		now := time.Now()
+		for _, item := range tickets {
+			alerts := support.dueSLAAlerts(item.ticket, now, false)
+			if len(alerts) == 0 {
+				continue
+			}
+
+			client, err := pool.get(item.topic.BotToken)
+			if err != nil {
+				support.log.Error("Can`t initialize bot for SLA alerts", "Error", err)
+				continue
+			}
+
+			support.sendSLAAlerts(item.ticket, alerts, false, client)
+		}
+	}
+}
+
+// checkPurgedSLA is the last SLA check of the ticket whose topic is removed
+// by the nightly purge. The ticket is closed without being resolved, so
+// every target it still had to meet counts as breached.
+func (support *Support) checkPurgedSLA(topicData entity.TopicData, client *bot.Bot) {
+	ticket, found, err := support.ticket(topicData.GroupChatID, topicData.Ticket)
+	if err != nil || !found {
+		return
+	}
+
+	alerts := support.dueSLAAlerts(ticket, time.Now(), true)
+	if len(alerts) > 0 {
+		support.sendSLAAlerts(ticket, alerts, true, client)
+	}
+}
+
+// dueSLAAlerts returns the SLA alerts the ticket is due and hasn't got yet.
+// The final check reports every pending target as breached.
+func (support *Support) dueSLAAlerts(ticket entity.Ticket, now time.Time, final bool) []slaAlert {
+	warnPercent := support.settings.Tenant(ticket.GroupChatID).SLA.WarnPercent
+	if warnPercent <= 0 {
+		warnPercent = defaultSLAWarnPercent
+	}
+
+	var alerts []slaAlert
+	for _, metric := range support.pendingSLAMetrics(ticket, now) {
+		level := ""
+		switch {
+		case final || metric.elapsed >= metric.target:
+			level = slaBreach
+		case metric.elapsed >= metric.target*time.Duration(warnPercent)/100:
+			level = slaWarning
+		default:
+			continue
+		}
+
+		alert := slaAlert{metric: metric, level: level}
+		if !ticket.HasSLAAlert(alert.name()) {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	return alerts
+}
+
+func (support *Support) sendSLAAlerts(ticket entity.Ticket, alerts []slaAlert, purged bool, client *bot.Bot) {
+	names := make([]string, 0, len(alerts)*2)
+	for _, alert := range alerts {
+		text := fmt.Sprintf(
+			"⏱ SLA %s: ticket #%d (%s) %s time is %s of %s",
+			alert.level,
+			ticket.Number,
+			ticket.Priority,
+			alert.metric.name,
+			alert.metric.elapsed.Round(time.Minute),
+			alert.metric.target)
+		if purged {
+			text += ", the ticket is closed by the nightly purge"
+		} else {
+			support.notifyTopic(ticket.GroupChatID, ticket.TopicID, text, client)
+		}
+		support.alertOps(ticket.GroupChatID, text, client)
+
+		names = append(names, alert.name())
+		if alert.level == slaBreach {
+			names = append(names, alert.metric.name+" "+slaWarning)
+		}
+	}
+
+	_, _, err := support.updateTicket(ticket.GroupChatID, ticket.Number, func(ticket *entity.Ticket) error {
+		for _, name := range names {
+			if !ticket.HasSLAAlert(name) {
+				ticket.SLAAlerts = append(ticket.SLAAlerts, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		support.log.Error("Can`t save ticket SLA alerts", "Error", err)
+	}
+}
+
+func (support *Support) recordReply(ticket entity.Ticket, date int64) {
+	err := support.db.AddReply(
+		context.Background(),
+		fmt.Sprintf(ticketRepliesKey, ticket.GroupChatID, ticket.Number),
+		date)
+	if err != nil {
+		support.log.Error("Can`t record agent reply", "Error", err)
+	}
+}
+
+func priorityCommand(support *Support, cmd command) error {
+	priority := strings.ToLower(cmd.args)
+	if !entity.IsPriority(priority) {
+		return fmt.Errorf("usage: /priority low|normal|high|urgent")
+	}
+
+	ticket, found, err := support.updateTicket(cmd.topic.GroupChatID, cmd.topic.Ticket, func(ticket *entity.Ticket) error {
+		ticket.Priority = priority
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("ticket #%d of the topic %d not found", cmd.topic.Ticket, cmd.topic.TopicID)
+	}
+
+	support.applyTicketState(ticket, ticket.Status, cmd.topic, cmd.bot)
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, fmt.Sprintf("Ticket #%d priority is %s", ticket.Number, priority), cmd.bot)
+	return nil
+}
+
+func slaCommand(support *Support, cmd command) error {
+	ticket, err := support.topicTicket(cmd.topic)
+	if err != nil {
+		return err
+	}
+
+	replies, err := support.db.Replies(
+		context.Background(),
+		fmt.Sprintf(ticketRepliesKey, ticket.GroupChatID, ticket.Number))
+	if err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Ticket #%d, priority %s\n", ticket.Number, ticket.Priority)
+	fmt.Fprintf(&text, "First response: %s\n", formatSLADuration(ticket.FirstResponseTime()))
+	fmt.Fprintf(&text, "Resolution: %s\n", formatSLADuration(ticket.ResolutionTime()))
+	fmt.Fprintf(&text, "Agent replies: %d", len(replies))
+
+	if target, ok := support.slaTarget(ticket); ok {
+		fmt.Fprintf(
+			&text,
+			"\nTargets: first response %s, resolution %s",
+			time.Duration(target.FirstResponseMinutes)*time.Minute,
+			time.Duration(target.ResolutionMinutes)*time.Minute)
+	}
+
+	support.notifyTopic(cmd.msg.ChatID, cmd.msg.TopicID, text.String(), cmd.bot)
+	return nil
+}
+
+func formatSLADuration(duration time.Duration) string {
+	if duration == 0 {
+		return "pending"
+	}
+	return duration.Round(time.Minute).String()
+}
@@ -0,0 +1,110 @@
+package supportline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+)
+
+func newSLASupport() *Support {
+	return &Support{settings: config.SupportConfig{Default: config.TenantConfig{
+		SLA: config.SLAConfig{
+			Targets: map[string]config.SLATarget{
+				entity.PriorityNormal: {FirstResponseMinutes: 60, ResolutionMinutes: 480},
+				"urgent": {FirstResponseMinutes: 15, ResolutionMinutes: 120},
+			},
+		},
+	}}}
+}
+
+func alertNames(alerts []slaAlert) []string {
+	names := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		names = append(names, alert.name())
+	}
+	return names
+}
+
+func TestFirstResponseBreach(t *testing.T) {
+	support := newSLASupport()
+	created := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	ticket := entity.NewTicket(1, -100, 1, 1, 10, "telegram", created.Unix())
+	ticket.Priority = "urgent"
+
+	alerts := support.dueSLAAlerts(ticket, created.Add(10*time.Minute), false)
+	if names := alertNames(alerts); len(names) != 0 {
+		t.Fatalf("alerts after 10 of 15 minutes: %v, want none below the warning threshold", names)
+	}
+
+	alerts = support.dueSLAAlerts(ticket, created.Add(13*time.Minute), false)
+	if names := alertNames(alerts); len(names) != 1 || names[0] != "first response warning" {
+		t.Fatalf("alerts after 13 of 15 minutes: %v, want the first response warning", names)
+	}
+
+	alerts = support.dueSLAAlerts(ticket, created.Add(20*time.Minute), false)
+	if names := alertNames(alerts); len(names) != 1 || names[0] != "first response breach" {
+		t.Fatalf("alerts after 20 of 15 minutes: %v, want the first response breach only", names)
+	}
+
+	ticket.FirstResponseAt = created.Add(20 * time.Minute).Unix()
+	for _, metric := range support.pendingSLAMetrics(ticket, created.Add(30*time.Minute)) {
+		if metric.name == slaFirstResponse {
+			t.Error("the first response is still pending after the agent replied")
+		}
+	}
+}
+
+func TestResolutionBreach(t *testing.T) {
+	support := newSLASupport()
+	created := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	ticket := entity.NewTicket(2, -100, 1, 1, 10, "telegram", created.Unix())
+	ticket.Priority = "low"
+	ticket.FirstResponseAt = created.Add(time.Minute).Unix()
+
+	alerts := support.dueSLAAlerts(ticket, created.Add(9*time.Hour), false)
+	if len(alerts) != 1 || alerts[0].name() != "resolution breach" {
+		t.Fatalf("alerts after 9 hours: %v, want the resolution breach of the normal target", alertNames(alerts))
+	}
+	if alerts[0].metric.target != 8*time.Hour {
+		t.Errorf("low priority ticket uses the %s target, want the normal one", alerts[0].metric.target)
+	}
+
+	err := ticket.Transition(entity.TicketResolved, created.Add(10*time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics := support.pendingSLAMetrics(ticket, created.Add(11*time.Hour)); metrics != nil {
+		t.Errorf("resolved ticket still has pending targets %v", metrics)
+	}
+}
+
+func TestSLAAlertsAreSentOnce(t *testing.T) {
+	support := newSLASupport()
+	created := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	ticket := entity.NewTicket(3, -100, 1, 1, 10, "telegram", created.Unix())
+	ticket.SLAAlerts = []string{"first response warning", "first response breach", "resolution warning"}
+
+	alerts := support.dueSLAAlerts(ticket, created.Add(7*time.Hour), false)
+	if len(alerts) != 0 {
+		t.Errorf("alerts already sent are repeated: %v", alertNames(alerts))
+	}
+
+	alerts = support.dueSLAAlerts(ticket, created.Add(9*time.Hour), false)
+	if names := alertNames(alerts); len(names) != 1 || names[0] != "resolution breach" {
+		t.Errorf("alerts after the resolution target: %v, want only the new breach", names)
+	}
+}
+
+func TestPurgedTicketBreachesPendingTargets(t *testing.T) {
+	support := newSLASupport()
+	created := time.Date(2026, 10, 19, 23, 50, 0, 0, time.UTC)
+	ticket := entity.NewTicket(4, -100, 1, 1, 10, "telegram", created.Unix())
+
+	alerts := support.dueSLAAlerts(ticket, created.Add(10*time.Minute), true)
+	names := alertNames(alerts)
+	if len(names) != 2 || names[0] != "first response breach" || names[1] != "resolution breach" {
+		t.Errorf("alerts of the purged ticket: %v, want both targets breached", names)
+	}
+}
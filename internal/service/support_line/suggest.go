@@ -0,0 +1,237 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/internal/service/knowledge"
+)
+
+const (
+	suggestAction = "suggest"
+	suggestBurstKey = "chatid{%d}:topic:{%d}:suggest"
+	suggestSentKey = "chatid{%d}:suggest:{%d}"
+	// suggestBurst is the pause after which the next user message gets
+	// its own suggestions.
+	suggestBurst = time.Minute
+	suggestSentTTL = 7 * 24 * time.Hour
+	defaultSuggestions = 3
+	defaultSuggestionScore = 0.25
+	suggestionPreview = 120
+	suggestionExpired = "The suggestion is no longer available"
+	suggestionSent = "The reply is already sent"
+)
+
+// Suggester proposes replies to the user message for the agents.
+type Suggester interface {
+	Suggest(ctx context.Context, chatID int64, text string, limit int, minScore float64) ([]entity.Suggestion, error)
+}
+
+// localSuggester matches the message with the tenant macros and knowledge
+// base articles.
+type localSuggester struct {
+	support *Support
+}
+
+// SetSuggester replaces the local knowledge base suggester.
+func(support *Support) SetSuggester(suggester Suggester) {
+	support.suggester = suggester
+}
+
+func (suggester localSuggester) Suggest(ctx context.Context, chatID int64, text string, limit int, minScore float64) ([]entity.Suggestion, error) {
+	macros, err := suggester.support.Macros(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	articles, err := suggester.support.Articles(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]knowledge.Document, 0, len(macros)+len(articles))
+	candidates := make(map[string]entity.Suggestion, len(macros)+len(articles))
+	for _, macro := range macros {
+		id := entity.NewCallbackData(entity.SuggestionMacro, macro.Name)
+		docs = append(docs, knowledge.Document{ID: id, Text: strings.ReplaceAll(macro.Name, "_", " ") + " " + macro.Text})
+		candidates[id] = entity.Suggestion{Kind: entity.SuggestionMacro, ID: macro.Name, Title: "/m " + macro.Name, Text: macro.Text}
+	}
+	for _, article := range articles {
+		id := entity.NewCallbackData(entity.SuggestionFAQ, article.ID)
+		docs = append(docs, knowledge.Document{ID: id, Text: article.Question, Keywords: article.Keywords})
+		candidates[id] = entity.Suggestion{Kind: entity.SuggestionFAQ, ID: article.ID, Title: article.Question, Text: article.Answer}
+	}
+
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	matches := knowledge.NewIndex(docs).Match(text, limit, minScore)
+	suggestions := make([]entity.Suggestion, 0, len(matches))
+	for _, match := range matches {
+		suggestion := candidates[match.ID]
+		suggestion.Score = match.Score
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions, nil
+}
+
+// suggestReplies posts the suggested replies to the user message in the
+// topic, the agents send one with its button. Only the first message of a
+// burst gets suggestions.
+func (support *Support) suggestReplies(topicData entity.TopicData, telegramMessage entity.UserMessage, bot *bot.Bot) {
+	settings := support.settings.Tenant(topicData.GroupChatID).Suggestions
+	text := strings.TrimSpace(telegramMessage.Payload + " " + telegramMessage.Caption)
+	if !settings.Enabled || text == "" {
+		return
+	}
+
+	first, err := support.db.SetAlert(
+		context.Background(),
+		fmt.Sprintf(suggestBurstKey, topicData.GroupChatID, topicData.TopicID),
+		suggestBurst)
+	if err != nil {
+		support.log.Error("Can`t check suggestion burst", "Error", err)
+		return
+	}
+
+	if !first {
+		return
+	}
+
+	limit := settings.Limit
+	if limit <= 0 {
+		limit = defaultSuggestions
+	}
+
+	minScore := settings.MinScore
+	if minScore <= 0 {
+		minScore = defaultSuggestionScore
+	}
+
+	suggestions, err := support.suggester.Suggest(context.Background(), topicData.GroupChatID, text, limit, minScore)
+	if err != nil {
+		support.log.Error("Can`t suggest replies", "Error", err)
+		return
+	}
+
+	if len(suggestions) == 0 {
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("💡 Suggested replies:")
+	buttons := make([]telebot.InlineButton, 0, len(suggestions))
+	for i, suggestion := range suggestions {
+		fmt.Fprintf(&message, "\n\n%d. %s\n%s", i+1, suggestion.Title, preview(suggestion.Text))
+		buttons = append(buttons, telebot.InlineButton{
+			Text: fmt.Sprintf("Send %d", i+1),
+			Data: entity.NewCallbackData(suggestAction, suggestion.Kind, suggestion.ID),
+		})
+	}
+
+	_, err = bot.Send(
+		telebot.ChatID(topicData.GroupChatID),
+		message.String(),
+		&telebot.SendOptions{
+			ThreadID: topicData.TopicID,
+			ReplyMarkup: &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{buttons}},
+		})
+	if err != nil {
+		support.log.Error("Can`t post suggested replies", "Error", err)
+	}
+}
+
+// sendSuggestion handles the send button of the suggested reply. The
+// suggestion message is claimed first, so concurrent taps send one reply.
+func (support *Support) sendSuggestion(callback entity.CallbackMessage, args []string, bot *bot.Bot) error {
+	if len(args) != 2 {
+		return fmt.Errorf("invalid suggestion callback %s", callback.Data)
+	}
+
+	sentKey := fmt.Sprintf(suggestSentKey, callback.ChatID, callback.MessageID)
+	first, err := support.db.SetAlert(context.Background(), sentKey, suggestSentTTL)
+	if err != nil {
+		return err
+	}
+
+	if !first {
+		return bot.Respond(callback.CallbackID, suggestionSent)
+	}
+
+	sent, err := support.sendSuggested(callback, args, bot)
+	if !sent {
+		releaseErr := support.db.DeleteKey(context.Background(), sentKey)
+		if releaseErr != nil {
+			support.log.Error("Can`t release suggestion", "Error", releaseErr)
+		}
+	}
+	return err
+}
+
+// sendSuggested sends the suggested reply to the user and reports whether
+// it was sent.
+func (support *Support) sendSuggested(callback entity.CallbackMessage, args []string, bot *bot.Bot) (bool, error) {
+	topicData, err := support.topicByKey(fmt.Sprintf(topicSupportKey, callback.ChatID, callback.TopicID))
+	if err != nil {
+		return false, err
+	}
+
+	supportMsg := callback.SupportMessage()
+	switch args[0] {
+	case entity.SuggestionMacro:
+		macro, found, err := support.macro(callback.ChatID, args[1])
+		if err != nil {
+			return false, err
+		}
+
+		if !found {
+			return false, bot.Respond(callback.CallbackID, suggestionExpired)
+		}
+
+		err = support.sendMacro(supportMsg, topicData, macro, bot)
+		if err != nil {
+			return false, err
+		}
+	case entity.SuggestionFAQ:
+		article, found, err := support.article(callback.ChatID, args[1])
+		if err != nil {
+			return false, err
+		}
+
+		if !found {
+			return false, bot.Respond(callback.CallbackID, suggestionExpired)
+		}
+
+		err = support.replyToUser(supportMsg, topicData, article.Answer, bot)
+		if err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("unknown suggestion kind %s", args[0])
+	}
+
+	_, err = bot.EditReplyMarkup(
+		&telebot.Message{ID: callback.MessageID, Chat: &telebot.Chat{ID: callback.ChatID}},
+		nil)
+	if err != nil {
+		support.log.Error("Can`t remove suggestion buttons", "Error", err)
+	}
+
+	return true, bot.Respond(callback.CallbackID, "Sent")
+}
+
+func preview(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= suggestionPreview {
+		return string(runes)
+	}
+	return string(runes[:suggestionPreview]) + "…"
+}
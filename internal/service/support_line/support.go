@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/robfig/cron"
 	"gopkg.in/telebot.v3"
 
+	"github.com/behummble/support_line_bot/internal/config"
 	"github.com/behummble/support_line_bot/internal/entity"
 	"github.com/behummble/support_line_bot/internal/service/bot"
 	"github.com/behummble/support_line_bot/pkg/crypto"
@@ -26,30 +28,117 @@ type DB interface {
 	NewTopic(ctx context.Context, topicUserKey, topicSupportKey, topicListKey, topicData string) error
 	Topic(ctx context.Context, topic string) (string, error)
 	AllTopics(ctx context.Context, keys string) ([]string, error)
-	ClearTopics(ctx context.Context) error
+	RemoveTopic(ctx context.Context, topicSupportKey, topicListKey string, relatedKeys ...string) error
+	ClearTopics(ctx context.Context, keys ...string) error
+	NextTicketNumber(ctx context.Context, key string) (int64, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Profile(ctx context.Context, key string) (string, error)
+	SetProfile(ctx context.Context, key, profile string) error
+	Ticket(ctx context.Context, key string) (string, error)
+	SetTicket(ctx context.Context, key, ticket string) error
+	UpdateTicket(ctx context.Context, key string, change func(ticket string) (string, error)) error
+	AddReply(ctx context.Context, key string, date int64) error
+	Replies(ctx context.Context, key string) ([]string, error)
+	UserState(ctx context.Context, key string) (string, error)
+	SetUserState(ctx context.Context, key, state string, ttl time.Duration) error
+	Rating(ctx context.Context, key string) (string, error)
+	SetRating(ctx context.Context, key, indexKey, rating string, date int64) error
+	RatingKeys(ctx context.Context, indexKey string, from, to int64) ([]string, error)
+	IndexTicket(ctx context.Context, indexKey string, number, date int64) error
+	TicketNumbers(ctx context.Context, indexKey string, from, to int64) ([]string, error)
+	AddHistory(ctx context.Context, key, entry string, ttl time.Duration) error
+	IndexTerms(ctx context.Context, termKeys []string, number int64, now time.Time, ttl time.Duration) error
+	SearchTerms(ctx context.Context, since time.Time, termKeys ...string) ([]string, error)
+	History(ctx context.Context, key string) ([]string, error)
+	Bans(ctx context.Context, key string) (map[string]string, error)
+	Ban(ctx context.Context, key, userID string) (string, error)
+	SetBan(ctx context.Context, key, userID, ban string) error
+	RemoveBan(ctx context.Context, key, userID string) error
+	Macros(ctx context.Context, key string) (map[string]string, error)
+	Macro(ctx context.Context, key, name string) (string, error)
+	SetMacro(ctx context.Context, key, name, macro string) error
+	RemoveMacro(ctx context.Context, key, name string) error
+	Articles(ctx context.Context, key string) (map[string]string, error)
+	Article(ctx context.Context, key, id string) (string, error)
+	SetArticle(ctx context.Context, key, id, article string) error
+	RemoveArticle(ctx context.Context, key, id string) error
+	IncrementStats(ctx context.Context, key string, ttl time.Duration, fields ...string) error
+	Stats(ctx context.Context, keys ...string) ([]map[string]string, error)
+	Agents(ctx context.Context, key string) (map[string]string, error)
+	Agent(ctx context.Context, key, field string) (string, error)
+	SetAgent(ctx context.Context, key, field, agent string) error
+	RemoveAgent(ctx context.Context, key, field string) error
+	AgentLoads(ctx context.Context, key string) (map[string]string, error)
+	ChangeAgentLoad(ctx context.Context, key, field string, delta int64) error
+	NextAgentTurn(ctx context.Context, key string) (int64, error)
+	Shifts(ctx context.Context, key string) (map[string]string, error)
+	SetShifts(ctx context.Context, key, field, shifts string) error
+	RemoveShifts(ctx context.Context, key, field string) error
+	DutyStatus(ctx context.Context, key string) (string, error)
+	SetDutyStatus(ctx context.Context, key, status string, ttl time.Duration) error
+	AddDelivery(ctx context.Context, key, delivery string, limit int64) error
+	Deliveries(ctx context.Context, key string) ([]string, error)
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	DeleteKey(ctx context.Context, key string) error
+	MigrateChat(ctx context.Context, moves []entity.KeyMove, topicListKey string) error
+	ChatMigration(ctx context.Context, key string) (int64, error)
+	EnqueueMessage(ctx context.Context, queueKey, queueListKey string, chatID int64, msg string) error
+	QueuedMessage(ctx context.Context, queueKey string) (string, error)
+	DequeueMessage(ctx context.Context, queueKey string) error
+	QueueLength(ctx context.Context, queueKey string) (int64, error)
+	QueuedChats(ctx context.Context, queueListKey string) ([]string, error)
+	AddQueuedChat(ctx context.Context, queueListKey string, chatID int64) error
+	RemoveQueuedChat(ctx context.Context, queueListKey string, chatID int64) error
+	CountMessages(ctx context.Context, key string, now time.Time, window time.Duration) (int64, error)
+	HoldMessage(ctx context.Context, key, listKey, member, msg string) error
+	HeldLists(ctx context.Context, listKey string) ([]string, error)
+	ReleaseHeldList(ctx context.Context, key, listKey, member string) error
+	DropHeldMessages(ctx context.Context, key string) error
+	SwapUserState(ctx context.Context, key, state string, ttl time.Duration) (string, error)
+	TakeUserState(ctx context.Context, key string) (string, error)
+	TrackMessage(ctx context.Context, key, member string, now time.Time, window time.Duration) (int64, error)
+	SetAlert(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	ClearAlert(ctx context.Context, key string) error
+}
+
+// Storage keeps the topic archives, Put returns the location of the object.
+type Storage interface {
+	Put(ctx context.Context, name, contentType string, data []byte) (string, error)
 }
 
 type Support struct {
 	log *slog.Logger
 	db DB
+	storage Storage
+	token string
 	chatID int64
 	timeout int
 	cron *cron.Cron
+	location *time.Location
+	settings config.SupportConfig
+	suggester Suggester
 }
 
-func New(log *slog.Logger, db DB, chatID int64, timeout int) *Support {
+func New(log *slog.Logger, db DB, storage Storage, token string, chatID int64, timeout int, settings config.SupportConfig) *Support {
 	loc, err := time.LoadLocation("Europe/Moscow")
 	if err != nil {
 		panic(err)
 	}
 
-	return &Support{
+	support := &Support{
 		log: log,
 		db: db,
+		storage: storage,
+		token: token,
 		chatID: chatID,
 		timeout: timeout,
 		cron: cron.NewWithLocation(loc),
+		location: loc,
+		settings: settings,
 	}
+	support.suggester = localSuggester{support: support}
+
+	return support
 }
 
 func(support *Support) ProcessUserMessage(msg []byte) {
@@ -67,13 +156,7 @@ func(support *Support) ProcessUserMessage(msg []byte) {
 	}
 	defer bot.Close()
 
-	supportChat, err := bot.ChatByID(telegramMessage.GroupChatID)
-	if err != nil {
-		support.log.Error("Can`t initialize chat while process user message", "Error", err)
-		return
-	}
-
-	err = support.handleUserMessage(telegramMessage, bot, supportChat)
+	err = support.deliverUserMessage(telegramMessage, bot)
 	if err != nil {
 		support.log.Error("Handle user message", "Error", err)
 	}
@@ -99,12 +182,78 @@ func(support *Support) ProcessSupportMessage(msg []byte) {
 	}
 }
 
-func(support *Support) RemoveTopics() {
+func(support *Support) ProcessUserCallback(msg []byte) {
+	callback, err := entity.NewCallbackMessageFromJSON(msg)
+	if err != nil {
+		support.log.Error("Can`t parse user callback", "Error", err)
+		return
+	}
+
+	bot, err := bot.New(support.log, callback.BotToken, support.timeout)
+	if err != nil {
+		support.log.Error("Can`t initialize bot while process user callback", "Error", err)
+		return
+	}
+	defer bot.Close()
+
+	err = support.handleUserCallback(callback, bot)
+	if err != nil {
+		support.log.Error("Handle user callback", "Error", err)
+	}
+}
+
+func(support *Support) ProcessSupportCallback(msg []byte) {
+	callback, err := entity.NewCallbackMessageFromJSON(msg)
+	if err != nil {
+		support.log.Error("Can`t parse support callback", "Error", err)
+		return
+	}
+
+	bot, err := bot.New(support.log, callback.BotToken, support.timeout)
+	if err != nil {
+		support.log.Error("Can`t initialize bot while process support callback", "Error", err)
+		return
+	}
+	defer bot.Close()
+
+	err = support.handleSupportCallback(callback, bot)
+	if err != nil {
+		support.log.Error("Handle support callback", "Error", err)
+	}
+}
+
+func(support *Support) Schedule() {
+	support.loadChatAliases()
 	support.cron.AddFunc("@midnight", support.clearTopicsFunc())
+	support.cron.AddFunc("@every 1m", support.retryQueuedMessagesFunc())
+	support.cron.AddFunc("@every 1m", support.remindWaitingUsersFunc())
+	support.cron.AddFunc("@every 1m", support.checkSLAFunc())
+	support.cron.AddFunc("@every 10s", support.releaseHeldMessagesFunc())
 	support.cron.Start()
 }
 
 func(support *Support) handleUserMessage(telegramMessage entity.UserMessage, bot *bot.Bot, supportChat *telebot.Chat) error {
+	banned, err := support.rejectBanned(telegramMessage, bot)
+	if err != nil || banned {
+		return err
+	}
+
+	throttled, err := support.throttle(telegramMessage, bot)
+	if err != nil || throttled {
+		return err
+	}
+
+	return support.forwardUserMessage(telegramMessage, bot, supportChat)
+}
+
+// forwardUserMessage passes the message that got through the ban and the
+// flood checks to the topic of the user, opening one if needed.
+func(support *Support) forwardUserMessage(telegramMessage entity.UserMessage, bot *bot.Bot, supportChat *telebot.Chat) error {
+	commented, err := support.commentRating(telegramMessage, bot)
+	if err != nil || commented {
+		return err
+	}
+
 	topic, err := support.db.Topic(
 		context.Background(), 
 		fmt.Sprintf(topicUserKey, telegramMessage.GroupChatID, telegramMessage.UserID))
@@ -123,13 +272,73 @@ func(support *Support) handleUserMessage(telegramMessage entity.UserMessage, bot
 			return err
 		}
 
-		return support.transferMessageToTopic(topicData.TopicID, telegramMessage, bot, supportChat)
+		err = support.transferMessageToTopic(topicData, telegramMessage, bot, supportChat)
+		if isTopicDeleted(err) {
+			return support.recreateTopic(topicData, telegramMessage, bot, supportChat)
+		}
+
+		if err == nil {
+			support.syncProfile(telegramMessage, topicData, bot)
+			support.userReplied(topicData, bot)
+		}
+		return err
 	} else {
-		return support.createTopic(telegramMessage, bot, supportChat)
+		triage, ready, err := support.resumeFAQ(telegramMessage)
+		if err != nil {
+			return err
+		}
+
+		if !ready {
+			triage, ready, err = support.triage(telegramMessage, bot)
+			if err != nil || !ready {
+				return err
+			}
+
+			ready, err = support.offerFAQ(triage, bot)
+			if err != nil || !ready {
+				return err
+			}
+		}
+
+		return support.openTriagedTopic(triage, bot, supportChat)
+	}
+}
+
+func(support *Support) handleUserCallback(callback entity.CallbackMessage, bot *bot.Bot) error {
+	chatID, err := support.resolveChatID(callback.GroupChatID)
+	if err != nil {
+		return err
+	}
+	callback.GroupChatID = chatID
+
+	action, args := callback.Action()
+	switch action {
+	case csatAction:
+		return support.rateTicket(callback, args, bot)
+	case triageAction:
+		return support.chooseCategory(callback, args, bot)
+	case faqAction:
+		return support.answerFAQ(callback, args, bot)
+	default:
+		return fmt.Errorf("unknown callback action %s", action)
+	}
+}
+
+func(support *Support) handleSupportCallback(callback entity.CallbackMessage, bot *bot.Bot) error {
+	action, args := callback.Action()
+	switch action {
+	case suggestAction:
+		return support.sendSuggestion(callback, args, bot)
+	default:
+		return fmt.Errorf("unknown support callback action %s", action)
 	}
 }
 
 func(support *Support) handleSupportMessage(supportMsg entity.SupportMessage, bot *bot.Bot) error {
+	if support.runAdminCommand(supportMsg, bot) {
+		return nil
+	}
+
 	topicInfo, err := support.db.Topic(
 		context.Background(), 
 		fmt.Sprintf(topicSupportKey, supportMsg.ChatID, supportMsg.TopicID))
@@ -148,15 +357,24 @@ func(support *Support) handleSupportMessage(supportMsg entity.SupportMessage, bo
 		if err != nil {
 			return err
 		}
-		return support.transferMessageToUser(topicData.ChatID, supportMsg.Payload, bot)
+
+		if support.runTopicCommand(supportMsg, topicData, bot) {
+			return nil
+		}
+
+		if support.isNote(supportMsg, topicData) {
+			return support.saveNote(supportMsg, topicData)
+		}
+
+		return support.replyToUser(supportMsg, topicData, supportMsg.Payload, bot)
 	} else {
 		return fmt.Errorf("couldn't find the topic %d from the support message %s", supportMsg.TopicID, supportMsg.Payload)
 	}
 }
 
-func (support *Support) transferMessageToTopic(topicID int, telegramMessage entity.UserMessage, bot *bot.Bot, supportChat *telebot.Chat) error {
+func (support *Support) transferMessageToTopic(topicData entity.TopicData, telegramMessage entity.UserMessage, bot *bot.Bot, supportChat *telebot.Chat) error {
 	opts := &telebot.SendOptions{
-		ThreadID: topicID,
+		ThreadID: topicData.TopicID,
 	}
 
 	userChat, err := bot.ChatByID(telegramMessage.ChatID)
@@ -172,57 +390,211 @@ func (support *Support) transferMessageToTopic(topicID int, telegramMessage enti
 		supportChat, 
 		msg, 
 		opts)
+	if err == nil {
+		support.recordUserMessage(topicData, telegramMessage)
+		support.suggestReplies(topicData, telegramMessage, bot)
+	}
 	
 	return err
 }
 
+// replyToUser delivers the agent reply to the user and records its outcome.
+func (support *Support) replyToUser(supportMsg entity.SupportMessage, topicData entity.TopicData, text string, bot *bot.Bot) error {
+	signed, err := support.sign(supportMsg, text)
+	if err != nil {
+		support.log.Error("Can`t sign the agent reply", "Error", err)
+	} else {
+		text = signed
+	}
+
+	err = support.transferMessageToUser(topicData.ChatID, text, bot)
+	support.reportDelivery(supportMsg, err, bot)
+	if err == nil {
+		support.agentReplied(topicData)
+		support.recordAgentReply(supportMsg, topicData, text)
+	}
+	return err
+}
+
 func (support *Support) transferMessageToUser(chatID int64, payload string, bot *bot.Bot) error {
 	_, err := bot.Send(telebot.ChatID(chatID), payload, &telebot.SendOptions{})
 	return err
 }
 
-func (support *Support) createTopic(telegramMessage entity.UserMessage, bot *bot.Bot, supportChat *telebot.Chat) error {
-	topic, err := bot.CreateTopic(supportChat, generateTopic(telegramMessage.UserName))
+func (support *Support) createTopic(telegramMessage entity.UserMessage, triage entity.TriageState, bot *bot.Bot, supportChat *telebot.Chat) error {
+	number, err := support.nextTicketNumber(telegramMessage.GroupChatID)
 	if err != nil {
 		return err
 	}
 
-	topicData, err := encoding.ToJSON(entity.NewTopic(
-		bot.Token(),
+	profile, err := support.loadProfile(telegramMessage)
+	if err != nil {
+		return err
+	}
+	profile.TicketCount++
+
+	ticket := entity.NewTicket(
+		number,
+		telegramMessage.GroupChatID,
 		telegramMessage.ChatID,
 		telegramMessage.UserID,
-		telegramMessage.GroupChatID,
-		topic.ThreadID,))
-		
+		0,
+		telegramMessage.Source,
+		time.Now().Unix())
+
+	ticket.BotToken = telegramMessage.BotToken
+	ticket.Category = triage.Category
+	ticket.Answers = triage.Answers
+	support.markRepeatContact(&ticket, profile)
+	profile.LastTicket = ticket.Number
+	profile.LastTicketAt = ticket.CreatedAt
+
+	closed, nextOpen := support.afterHours(telegramMessage.GroupChatID, time.Now())
+	ticket.AfterHours = closed
+
+	agent, assigned, err := support.pickAgent(telegramMessage.GroupChatID)
+	if err != nil {
+		support.log.Error("Can`t pick agent for the ticket", "Error", err)
+	}
+	if assigned {
+		ticket.Assignee = agent.Key()
+	}
+
+	topicData, err := support.openTopic(&ticket, profile, bot, supportChat)
 	if err != nil {
 		return err
 	}
+	support.indexTicket(ticket, profile)
+
+	if assigned {
+		support.changeAgentLoad(ticket.GroupChatID, ticket.Assignee, 1)
+		support.mentionAssignee(ticket, fmt.Sprintf("new ticket #%d is assigned to you", ticket.Number), bot)
+	}
+	support.warnNobodyOnDuty(ticket, bot)
+	support.noticeRepeatContact(ticket, bot)
+
+	if closed {
+		support.notifyTopic(ticket.GroupChatID, ticket.TopicID, afterHoursNotice, bot)
+		support.replyAfterHours(ticket, nextOpen, bot)
+	}
 
-	encryptTopicData, err := crypto.EncryptData(topicData)
+	err = support.saveProfile(telegramMessage.GroupChatID, profile)
 	if err != nil {
 		return err
 	}
 
+	err = support.transferMessageToTopic(topicData, telegramMessage, bot, supportChat)
+	if err != nil {
+		return err
+	}
+
+	support.greet(ticket, profile, bot)
+	return nil
+}
+
+// openTopic creates the forum topic for the ticket, posts the profile card
+// and stores the topic mapping.
+func (support *Support) openTopic(ticket *entity.Ticket, profile entity.UserProfile, bot *bot.Bot, supportChat *telebot.Chat) (entity.TopicData, error) {
+	topic, err := bot.CreateTopic(
+		supportChat, 
+		support.generateTopic(ticket.GroupChatID, newTopicNameData(profile, *ticket)))
+	if err != nil {
+		return entity.TopicData{}, err
+	}
+	ticket.TopicID = topic.ThreadID
+
+	cardMessageID, err := support.postCard(
+		cardData{Profile: profile, Ticket: *ticket, Assignee: support.assigneeName(*ticket)}, 
+		topic.ThreadID, 
+		bot, 
+		supportChat)
+	if err != nil {
+		support.log.Error("Can`t post profile card to the topic", "Error", err)
+	}
+
+	err = support.saveTicket(*ticket)
+	if err != nil {
+		return entity.TopicData{}, err
+	}
+
+	topicData := entity.NewTopic(
+		bot.Token(),
+		ticket.ChatID,
+		ticket.UserID,
+		ticket.GroupChatID,
+		topic.ThreadID,
+		ticket.Number,
+		cardMessageID)
+
+	jsonTopic, err := encoding.ToJSON(topicData)
+	if err != nil {
+		return entity.TopicData{}, err
+	}
+
+	encryptTopicData, err := crypto.EncryptData(jsonTopic)
+	if err != nil {
+		return entity.TopicData{}, err
+	}
+
 	err = support.db.NewTopic(
 		context.Background(),
-		fmt.Sprintf(topicUserKey, telegramMessage.GroupChatID, telegramMessage.UserID),
-		fmt.Sprintf(topicSupportKey, telegramMessage.GroupChatID, topic.ThreadID),
+		fmt.Sprintf(topicUserKey, ticket.GroupChatID, ticket.UserID),
+		fmt.Sprintf(topicSupportKey, ticket.GroupChatID, topic.ThreadID),
 		allTopics,
 		encryptTopicData,
 	)
 
+	return topicData, err
+}
+
+func (support *Support) recreateTopic(topicData entity.TopicData, telegramMessage entity.UserMessage, bot *bot.Bot, supportChat *telebot.Chat) error {
+	support.log.Warn(
+		"Topic was deleted in the support chat, recreating it", 
+		"ChatID", topicData.GroupChatID, 
+		"TopicID", topicData.TopicID)
+
+	err := support.db.RemoveTopic(
+		context.Background(),
+		fmt.Sprintf(topicSupportKey, topicData.GroupChatID, topicData.TopicID),
+		allTopics,
+		fmt.Sprintf(topicDeliveryKey, topicData.GroupChatID, topicData.TopicID))
+	if err != nil {
+		return err
+	}
+
+	ticket, found, err := support.ticket(topicData.GroupChatID, topicData.Ticket)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return support.createTopic(telegramMessage, entity.TriageState{}, bot, supportChat)
+	}
+
+	profile, err := support.loadProfile(telegramMessage)
+	if err != nil {
+		return err
+	}
+
+	newTopicData, err := support.openTopic(&ticket, profile, bot, supportChat)
 	if err != nil {
 		return err
-	} else {
-		return support.transferMessageToTopic(topic.ThreadID, telegramMessage, bot, supportChat)
 	}
+
+	return support.transferMessageToTopic(newTopicData, telegramMessage, bot, supportChat)
 }
 
-func generateTopic(userName string) *telebot.Topic {
-	return &telebot.Topic{
-			Name: userName,
-			IconColor: 0,
-		}
+// isTopicDeleted reports whether the forward failed because the topic
+// was removed from the support chat by hand.
+func isTopicDeleted(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	description := err.Error()
+	return strings.Contains(description, "message thread not found") ||
+		strings.Contains(description, "TOPIC_DELETED") ||
+		strings.Contains(description, "TOPIC_ID_INVALID")
 }
 
 func (support *Support) clearTopicsFunc() func() {
@@ -295,6 +667,13 @@ func (support *Support) deleteTopicsInService() {
 			supportChat := groupChats[topicData.GroupChatID]
 			mutex.Unlock()
 
+			support.checkPurgedSLA(topicData, bot)
+
+			err = support.archiveTopic(topicData, archivePurged, bot)
+			if err != nil {
+				support.log.Error("Can`t archive topic before delete", "Ticket", topicData.Ticket, "Error", err)
+			}
+
 			teleTopic := &telebot.Topic {
 				ThreadID: topicData.TopicID,
 			}
@@ -317,8 +696,34 @@ func (support *Support) deleteTopicsInService() {
 	support.log.Info("Finished delete topics")
 }
 
+// deleteTopicsInDB removes only the topic mappings. The database also keeps
+// the tickets, profiles, ratings and tenant settings that outlive the
+// topics, so it is not flushed as a whole.
 func (sbot *Support) deleteTopicsInDB() {
-	err := sbot.db.ClearTopics(context.Background())
+	keys, err := sbot.db.AllTopics(context.Background(), allTopics)
+	if err != nil {
+		sbot.log.Error("Failed to get all topics", "Error", err)
+		return
+	}
+
+	topicKeys := []string{allTopics}
+	for _, key := range keys {
+		topicKeys = append(topicKeys, key)
+
+		topicData, err := sbot.topicByKey(key)
+		if err != nil {
+			sbot.log.Error("Can`t read topic data before flush", "Error", err)
+			continue
+		}
+		sbot.closePurgedTicket(topicData)
+
+		topicKeys = append(
+			topicKeys,
+			fmt.Sprintf(topicUserKey, topicData.GroupChatID, topicData.UserID),
+			fmt.Sprintf(topicDeliveryKey, topicData.GroupChatID, topicData.TopicID))
+	}
+
+	err = sbot.db.ClearTopics(context.Background(), topicKeys...)
 	if err != nil {
 		sbot.log.Error("Sheduled flush topics in DB failed", "Error", err)
 	}
@@ -0,0 +1,336 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	ratingKey = "chatid{%d}:rating:{%d}"
+	ratingsKey = "chatid{%d}:ratings"
+	csatCommentKey = "chatid{%d}:user:{%d}:csat"
+	csatAction = "csat"
+	csatComment = "comment"
+	maxScore = 5
+	defaultSurveyQuestion = "How would you rate our support?"
+	defaultSurveyThanks = "Thank you for the rating!"
+	defaultCommentWindow = 10 * time.Minute
+	defaultCommentButton = "💬 Add a comment"
+	defaultCommentPrompt = "Please write your comment in the next message."
+	surveyFailed = "Sorry, the rating couldn't be saved"
+)
+
+func(support *Support) CSATReport(chatID int64, from, to time.Time) (entity.CSATReport, error) {
+	keys, err := support.db.RatingKeys(
+		context.Background(),
+		fmt.Sprintf(ratingsKey, chatID),
+		from.Unix(),
+		to.Unix())
+	if err != nil {
+		return entity.CSATReport{}, err
+	}
+
+	ratings := make([]entity.Rating, 0, len(keys))
+	for _, key := range keys {
+		data, err := support.db.Rating(context.Background(), key)
+		if err != nil {
+			return entity.CSATReport{}, err
+		}
+
+		if data == "" {
+			continue
+		}
+
+		rating, err := entity.NewRatingFromJSON([]byte(data))
+		if err != nil {
+			return entity.CSATReport{}, err
+		}
+		ratings = append(ratings, rating)
+	}
+
+	return entity.NewCSATReport(ratings), nil
+}
+
+// sendSurvey asks the user to rate the support once per ticket.
+func (support *Support) sendSurvey(ticket entity.Ticket, bot *bot.Bot) {
+	survey := support.settings.Tenant(ticket.GroupChatID).Survey
+	if !survey.Enabled || ticket.SurveySent {
+		return
+	}
+
+	// Mark the survey as sent first, so two resolutions racing each other
+	// don't ask the user twice.
+	claimed := false
+	_, _, err := support.updateTicket(ticket.GroupChatID, ticket.Number, func(ticket *entity.Ticket) error {
+		claimed = !ticket.SurveySent
+		if !claimed {
+			return errTicketUnchanged
+		}
+
+		ticket.SurveySent = true
+		return nil
+	})
+	if err != nil {
+		support.log.Error("Can`t save survey state", "Error", err)
+		return
+	}
+
+	if !claimed {
+		return
+	}
+
+	profile, _, err := support.profile(ticket.GroupChatID, ticket.UserID)
+	if err != nil {
+		support.log.Error("Can`t load user profile", "Error", err)
+	}
+
+	question := localized(survey.Question, profile.LanguageCode)
+	if question == "" {
+		question = defaultSurveyQuestion
+	}
+
+	text, err := renderTemplate(question, autoReplyData{Ticket: ticket.Number, FirstName: profile.FirstName})
+	if err != nil {
+		support.log.Error("Can`t render survey question", "Error", err)
+		return
+	}
+
+	buttons := make([]telebot.InlineButton, 0, maxScore)
+	for score := 1; score <= maxScore; score++ {
+		buttons = append(buttons, telebot.InlineButton{
+			Text: strconv.Itoa(score) + "⭐️",
+			Data: entity.NewCallbackData(csatAction, ticket.Number, score),
+		})
+	}
+
+	_, err = bot.Send(
+		telebot.ChatID(ticket.ChatID),
+		text,
+		&telebot.SendOptions{
+			ReplyMarkup: &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{buttons}},
+		})
+	if err != nil {
+		support.log.Error("Can`t send satisfaction survey", "Error", err)
+		return
+	}
+
+}
+
+// rateTicket handles the survey buttons. The callback is answered even
+// when the rating fails, so the button doesn't keep loading.
+func (support *Support) rateTicket(callback entity.CallbackMessage, args []string, bot *bot.Bot) error {
+	err := support.answerSurvey(callback, args, bot)
+	if err != nil {
+		respondErr := bot.Respond(callback.CallbackID, surveyFailed)
+		if respondErr != nil {
+			support.log.Error("Can`t answer survey callback", "Error", respondErr)
+		}
+	}
+	return err
+}
+
+func (support *Support) answerSurvey(callback entity.CallbackMessage, args []string, bot *bot.Bot) error {
+	if len(args) != 2 {
+		return fmt.Errorf("invalid survey callback %s", callback.Data)
+	}
+
+	number, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	ticket, found, err := support.ticket(callback.GroupChatID, number)
+	if err != nil {
+		return err
+	}
+
+	if !found || ticket.UserID != callback.UserID {
+		return fmt.Errorf("ticket #%d of the user %d not found", number, callback.UserID)
+	}
+
+	if args[1] == csatComment {
+		return support.askComment(callback, ticket, bot)
+	}
+
+	score, err := strconv.Atoi(args[1])
+	if err != nil || score < 1 || score > maxScore {
+		return fmt.Errorf("invalid survey score %s", args[1])
+	}
+
+	rating := entity.NewRating(ticket, score, time.Now().Unix())
+	err = support.saveRating(rating)
+	if err != nil {
+		return err
+	}
+	support.recordCSAT(ticket, score)
+
+	err = bot.Respond(callback.CallbackID, fmt.Sprintf("%d/%d", score, maxScore))
+	if err != nil {
+		support.log.Error("Can`t answer survey callback", "Error", err)
+	}
+
+	profile, _, err := support.profile(ticket.GroupChatID, ticket.UserID)
+	if err != nil {
+		support.log.Error("Can`t load user profile", "Error", err)
+	}
+
+	survey := support.settings.Tenant(ticket.GroupChatID).Survey
+	thanks := localized(survey.Thanks, profile.LanguageCode)
+	if thanks == "" {
+		thanks = defaultSurveyThanks
+	}
+
+	commentButton := localized(survey.CommentButton, profile.LanguageCode)
+	if commentButton == "" {
+		commentButton = defaultCommentButton
+	}
+
+	_, err = bot.EditMessage(
+		&telebot.Message{ID: callback.MessageID, Chat: &telebot.Chat{ID: callback.ChatID}},
+		thanks,
+		&telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{{
+			Text: commentButton,
+			Data: entity.NewCallbackData(csatAction, ticket.Number, csatComment),
+		}}}})
+	if err != nil {
+		support.log.Error("Can`t update survey message", "Error", err)
+	}
+
+	support.notifyTopic(
+		ticket.GroupChatID,
+		ticket.TopicID,
+		fmt.Sprintf("⭐️ The user rated ticket #%d: %d/%d", ticket.Number, score, maxScore),
+		bot)
+	return nil
+}
+
+// askComment waits for the rating comment in the next user message.
+func (support *Support) askComment(callback entity.CallbackMessage, ticket entity.Ticket, bot *bot.Bot) error {
+	data, err := support.db.Rating(
+		context.Background(),
+		fmt.Sprintf(ratingKey, ticket.GroupChatID, ticket.Number))
+	if err != nil {
+		return err
+	}
+
+	if data == "" {
+		return fmt.Errorf("ticket #%d is not rated yet", ticket.Number)
+	}
+
+	survey := support.settings.Tenant(ticket.GroupChatID).Survey
+	window := defaultCommentWindow
+	if survey.CommentMinutes > 0 {
+		window = time.Duration(survey.CommentMinutes) * time.Minute
+	}
+
+	err = support.db.SetUserState(
+		context.Background(),
+		fmt.Sprintf(csatCommentKey, ticket.GroupChatID, ticket.UserID),
+		strconv.FormatInt(ticket.Number, 10),
+		window)
+	if err != nil {
+		return err
+	}
+
+	err = bot.Respond(callback.CallbackID, "")
+	if err != nil {
+		support.log.Error("Can`t answer survey callback", "Error", err)
+	}
+
+	_, err = bot.EditReplyMarkup(
+		&telebot.Message{ID: callback.MessageID, Chat: &telebot.Chat{ID: callback.ChatID}},
+		nil)
+	if err != nil {
+		support.log.Error("Can`t remove comment button", "Error", err)
+	}
+
+	profile, _, err := support.profile(ticket.GroupChatID, ticket.UserID)
+	if err != nil {
+		support.log.Error("Can`t load user profile", "Error", err)
+	}
+
+	prompt := localized(survey.CommentPrompt, profile.LanguageCode)
+	if prompt == "" {
+		prompt = defaultCommentPrompt
+	}
+
+	_, err = bot.Send(telebot.ChatID(ticket.ChatID), prompt, &telebot.SendOptions{})
+	return err
+}
+
+// commentRating stores the message the user sends after asking to comment
+// the rating and reports whether it did so.
+func (support *Support) commentRating(telegramMessage entity.UserMessage, bot *bot.Bot) (bool, error) {
+	text := strings.TrimSpace(telegramMessage.Payload)
+	if text == "" || strings.HasPrefix(text, "/") {
+		return false, nil
+	}
+
+	stateKey := fmt.Sprintf(csatCommentKey, telegramMessage.GroupChatID, telegramMessage.UserID)
+	state, err := support.db.UserState(context.Background(), stateKey)
+	if err != nil || state == "" {
+		return false, err
+	}
+
+	number, err := strconv.ParseInt(state, 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := support.db.Rating(
+		context.Background(),
+		fmt.Sprintf(ratingKey, telegramMessage.GroupChatID, number))
+	if err != nil || data == "" {
+		return false, err
+	}
+
+	rating, err := entity.NewRatingFromJSON([]byte(data))
+	if err != nil {
+		return false, err
+	}
+
+	rating.Comment = text
+	err = support.saveRating(rating)
+	if err != nil {
+		return false, err
+	}
+
+	err = support.db.DeleteKey(context.Background(), stateKey)
+	if err != nil {
+		support.log.Error("Can`t clear survey comment state", "Error", err)
+	}
+
+	ticket, found, err := support.ticket(rating.GroupChatID, rating.Ticket)
+	if err == nil && found {
+		support.notifyTopic(
+			ticket.GroupChatID,
+			ticket.TopicID,
+			fmt.Sprintf("💬 Rating comment for ticket #%d: %s", ticket.Number, text),
+			bot)
+	}
+
+	return true, nil
+}
+
+func (support *Support) saveRating(rating entity.Rating) error {
+	data, err := encoding.ToJSON(rating)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetRating(
+		context.Background(),
+		fmt.Sprintf(ratingKey, rating.GroupChatID, rating.Ticket),
+		fmt.Sprintf(ratingsKey, rating.GroupChatID),
+		string(data),
+		rating.Date)
+}
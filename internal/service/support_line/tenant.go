@@ -0,0 +1,27 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+)
+
+// KnownTenant tells whether the bot serves the chat: the chat has its own
+// settings, is the main support chat or has had tickets.
+func (support *Support) KnownTenant(chatID int64) (bool, error) {
+	if chatID == support.chatID || support.settings.Configured(chatID) {
+		return true, nil
+	}
+
+	return support.db.Exists(
+		context.Background(),
+		fmt.Sprintf(ticketNumberKey, chatID))
+}
+
+// APIToken is the token that opens the admin endpoints for the chat only,
+// empty when the tenant has none.
+func (support *Support) APIToken(chatID int64) string {
+	if !support.settings.Configured(chatID) {
+		return ""
+	}
+	return support.settings.Tenant(chatID).APIToken
+}
@@ -0,0 +1,310 @@
+package supportline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	ticketKey = "chatid{%d}:ticket:{%d}"
+	ticketsKey = "chatid{%d}:tickets"
+)
+
+var (
+	errTicketNotFound = errors.New("ticket not found")
+	// errTicketUnchanged lets the change passed to updateTicket skip the save.
+	errTicketUnchanged = errors.New("ticket unchanged")
+)
+
+func (support *Support) ticket(chatID, number int64) (entity.Ticket, bool, error) {
+	data, err := support.db.Ticket(
+		context.Background(),
+		fmt.Sprintf(ticketKey, chatID, number))
+	if err != nil || data == "" {
+		return entity.Ticket{}, false, err
+	}
+
+	ticket, err := decodeTicket(data)
+	return ticket, err == nil, err
+}
+
+func (support *Support) saveTicket(ticket entity.Ticket) error {
+	data, err := encodeTicket(ticket)
+	if err != nil {
+		return err
+	}
+
+	return support.db.SetTicket(
+		context.Background(),
+		fmt.Sprintf(ticketKey, ticket.GroupChatID, ticket.Number),
+		data)
+}
+
+// updateTicket applies the change to the stored ticket and saves it only if
+// nobody changed the ticket in the meantime, otherwise the change is applied
+// again to the fresh copy. The change may run several times, so it must not
+// have side effects; the caller acts on the returned ticket instead.
+func (support *Support) updateTicket(chatID, number int64, change func(ticket *entity.Ticket) error) (entity.Ticket, bool, error) {
+	var ticket entity.Ticket
+	err := support.db.UpdateTicket(
+		context.Background(),
+		fmt.Sprintf(ticketKey, chatID, number),
+		func(data string) (string, error) {
+			if data == "" {
+				return "", errTicketNotFound
+			}
+
+			var err error
+			ticket, err = decodeTicket(data)
+			if err != nil {
+				return "", err
+			}
+
+			err = change(&ticket)
+			if err != nil {
+				return "", err
+			}
+
+			return encodeTicket(ticket)
+		})
+	if err == errTicketNotFound {
+		return entity.Ticket{}, false, nil
+	}
+	if err == errTicketUnchanged {
+		return ticket, true, nil
+	}
+	if err != nil {
+		return entity.Ticket{}, false, err
+	}
+
+	return ticket, true, nil
+}
+
+func decodeTicket(data string) (entity.Ticket, error) {
+	jsonTicket, err := crypto.DecryptData(data)
+	if err != nil {
+		return entity.Ticket{}, err
+	}
+
+	return entity.NewTicketFromJSON([]byte(jsonTicket))
+}
+
+func encodeTicket(ticket entity.Ticket) (string, error) {
+	data, err := encoding.ToJSON(ticket)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.EncryptData(data)
+}
+
+// indexTicket makes the ticket searchable by its creation date and
+// by the user names.
+func (support *Support) indexTicket(ticket entity.Ticket, profile entity.UserProfile) {
+	err := support.db.IndexTicket(
+		context.Background(),
+		fmt.Sprintf(ticketsKey, ticket.GroupChatID),
+		ticket.Number,
+		ticket.CreatedAt)
+	if err != nil {
+		support.log.Error("Can`t index the ticket", "Ticket", ticket.Number, "Error", err)
+	}
+
+	support.indexText(
+		ticket.GroupChatID,
+		ticket.Number,
+		strconv.FormatInt(ticket.Number, 10),
+		ticket.Source,
+		profile.UserName,
+		profile.FirstName,
+		profile.LastName)
+}
+
+func (support *Support) topicTicket(topicData entity.TopicData) (entity.Ticket, error) {
+	ticket, found, err := support.ticket(topicData.GroupChatID, topicData.Ticket)
+	if err != nil {
+		return entity.Ticket{}, err
+	}
+
+	if !found {
+		return entity.Ticket{}, fmt.Errorf("ticket #%d of the topic %d not found", topicData.Ticket, topicData.TopicID)
+	}
+
+	return ticket, nil
+}
+
+func (support *Support) changeStatus(topicData entity.TopicData, status string, bot *bot.Bot) (entity.Ticket, error) {
+	var previous string
+	var wasActive bool
+	ticket, found, err := support.updateTicket(topicData.GroupChatID, topicData.Ticket, func(ticket *entity.Ticket) error {
+		previous = ticket.Status
+		wasActive = ticket.Active()
+		return ticket.Transition(status, time.Now().Unix())
+	})
+	if err != nil {
+		return entity.Ticket{}, err
+	}
+
+	if !found {
+		return entity.Ticket{}, fmt.Errorf("ticket #%d of the topic %d not found", topicData.Ticket, topicData.TopicID)
+	}
+
+	if wasActive != ticket.Active() {
+		support.changeAgentLoad(ticket.GroupChatID, ticket.Assignee, loadDelta(ticket.Active()))
+	}
+
+	support.applyTicketState(ticket, previous, topicData, bot)
+	if ticket.Status == entity.TicketResolved {
+		support.sendSurvey(ticket, bot)
+	}
+
+	return ticket, nil
+}
+
+// applyTicketState brings the topic name, icon, open state and the card
+// in line with the ticket status.
+func (support *Support) applyTicketState(ticket entity.Ticket, previous string, topicData entity.TopicData, bot *bot.Bot) {
+	supportChat := &telebot.Chat{ID: ticket.GroupChatID}
+	topic := &telebot.Topic{ThreadID: ticket.TopicID}
+
+	if previous == entity.TicketClosed && ticket.Status != entity.TicketClosed {
+		err := bot.ReopenTopic(supportChat, topic)
+		if err != nil && !isTopicNotModified(err) {
+			support.log.Error("Can`t reopen topic", "Error", err)
+		}
+	}
+
+	profile, _, err := support.profile(ticket.GroupChatID, ticket.UserID)
+	if err != nil {
+		support.log.Error("Can`t load user profile", "Error", err)
+	}
+
+	edited := support.generateTopic(ticket.GroupChatID, newTopicNameData(profile, ticket))
+	edited.ThreadID = ticket.TopicID
+	err = bot.EditTopic(supportChat, edited)
+	if err != nil && !isTopicNotModified(err) {
+		support.log.Error("Can`t edit topic", "Error", err)
+	}
+
+	if ticket.Status == entity.TicketClosed && previous != entity.TicketClosed {
+		err = support.archiveTopic(topicData, archiveClosed, bot)
+		if err != nil {
+			support.log.Error("Can`t archive closed topic", "Ticket", ticket.Number, "Error", err)
+		}
+
+		err = bot.CloseTopic(supportChat, topic)
+		if err != nil && !isTopicNotModified(err) {
+			support.log.Error("Can`t close topic", "Error", err)
+		}
+	}
+
+	err = support.refreshCard(topicData, bot)
+	if err != nil {
+		support.log.Error("Can`t update profile card", "Error", err)
+	}
+}
+
+// userReplied moves the ticket back to the agents when the user writes
+// while the ticket waits for them or is already done, answers outside
+// business hours and pings the assigned agent.
+func (support *Support) userReplied(topicData entity.TopicData, bot *bot.Bot) {
+	ticket, found, err := support.ticket(topicData.GroupChatID, topicData.Ticket)
+	if err != nil || !found {
+		return
+	}
+
+	switch ticket.Status {
+	case entity.TicketPendingUser, entity.TicketResolved, entity.TicketClosed:
+		ticket, err = support.changeStatus(topicData, entity.TicketPendingAgent, bot)
+		if err != nil {
+			support.log.Error("Can`t change ticket status", "Error", err)
+			return
+		}
+	}
+
+	closed, nextOpen := support.afterHours(ticket.GroupChatID, time.Now())
+	if closed {
+		if !ticket.AfterHours {
+			support.markAfterHours(&ticket, topicData, bot)
+		}
+		support.replyAfterHours(ticket, nextOpen, bot)
+	}
+
+	support.mentionAssignee(ticket, "the user wrote again", bot)
+}
+
+// agentReplied records the agent reply time for the SLA.
+func (support *Support) agentReplied(topicData entity.TopicData) {
+	now := time.Now().Unix()
+	ticket, found, err := support.updateTicket(topicData.GroupChatID, topicData.Ticket, func(ticket *entity.Ticket) error {
+		if ticket.FirstResponseAt != 0 {
+			return errTicketUnchanged
+		}
+
+		ticket.FirstResponseAt = now
+		return nil
+	})
+	if err != nil {
+		support.log.Error("Can`t save ticket first response", "Error", err)
+		return
+	}
+
+	if found {
+		support.recordReply(ticket, now)
+	}
+}
+
+// closePurgedTicket closes the ticket whose topic is removed by the
+// nightly purge.
+func (support *Support) closePurgedTicket(topicData entity.TopicData) {
+	var wasActive bool
+	ticket, found, err := support.updateTicket(topicData.GroupChatID, topicData.Ticket, func(ticket *entity.Ticket) error {
+		wasActive = false
+		if ticket.Status == entity.TicketClosed {
+			return errTicketUnchanged
+		}
+
+		wasActive = ticket.Active()
+		err := ticket.Transition(entity.TicketClosed, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+
+		if wasActive {
+			// Nobody resolved it, keep it out of the resolution time and the
+			// repeat contact check.
+			ticket.ResolvedAt = 0
+		}
+		return nil
+	})
+	if err != nil {
+		support.log.Error("Can`t close purged ticket", "Error", err)
+		return
+	}
+
+	if found && wasActive {
+		support.changeAgentLoad(ticket.GroupChatID, ticket.Assignee, -1)
+	}
+}
+
+func loadDelta(active bool) int64 {
+	if active {
+		return 1
+	}
+	return -1
+}
+
+func isTopicNotModified(err error) bool {
+	return strings.Contains(err.Error(), "TOPIC_NOT_MODIFIED")
+}
@@ -0,0 +1,134 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+)
+
+const (
+	ticketNumberKey = "chatid{%d}:ticket:counter"
+	defaultTopicName = "#{{.Ticket}} {{.FirstName}} {{.LastName}}{{if .UserName}} @{{.UserName}}{{end}}"
+	maxTopicNameLength = 128
+	afterHoursIcon = "after-hours"
+)
+
+type topicNameData struct {
+	FirstName string
+	LastName string
+	UserName string
+	UserID int64
+	Language string
+	Source string
+	Ticket int64
+	Status string
+	Priority string
+	AfterHours bool
+}
+
+func newTopicNameData(profile entity.UserProfile, ticket entity.Ticket) topicNameData {
+	return topicNameData{
+		FirstName: profile.FirstName,
+		LastName: profile.LastName,
+		UserName: profile.UserName,
+		UserID: profile.UserID,
+		Language: profile.LanguageCode,
+		Source: ticket.Source,
+		Ticket: ticket.Number,
+		Status: ticket.Status,
+		Priority: ticket.Priority,
+		AfterHours: ticket.AfterHours,
+	}
+}
+
+func (support *Support) nextTicketNumber(chatID int64) (int64, error) {
+	return support.db.NextTicketNumber(
+		context.Background(),
+		fmt.Sprintf(ticketNumberKey, chatID))
+}
+
+func (support *Support) generateTopic(chatID int64, data topicNameData) *telebot.Topic {
+	keys := []string{data.Priority, data.Status}
+	if data.AfterHours {
+		keys = append([]string{afterHoursIcon}, keys...)
+	}
+
+	icon := support.topicIcon(chatID, keys...)
+	return &telebot.Topic{
+		Name: support.topicName(chatID, data),
+		IconColor: icon.Color,
+		IconCustomEmojiID: icon.EmojiID,
+	}
+}
+
+func (support *Support) topicName(chatID int64, data topicNameData) string {
+	nameTemplate := support.settings.Tenant(chatID).Topic.NameTemplate
+	if nameTemplate == "" {
+		nameTemplate = defaultTopicName
+	}
+
+	name, err := renderTemplate(nameTemplate, data)
+	if err != nil {
+		support.log.Error("Can`t render topic name, using the default template", "Error", err)
+		name, _ = renderTemplate(defaultTopicName, data)
+	}
+
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		name = fmt.Sprintf("User %d", data.UserID)
+	}
+
+	runes := []rune(name)
+	if len(runes) > maxTopicNameLength {
+		name = string(runes[:maxTopicNameLength])
+	}
+
+	return name
+}
+
+// topicIcon returns the icon of the first key configured for the tenant.
+func (support *Support) topicIcon(chatID int64, keys ...string) config.TopicIcon {
+	icons := support.settings.Tenant(chatID).Topic.Icons
+	for _, key := range keys {
+		if icon, ok := icons[key]; ok {
+			return icon
+		}
+	}
+
+	return config.TopicIcon{}
+}
+
+func (support *Support) notifyTopic(chatID int64, topicID int, text string, bot *bot.Bot) {
+	_, err := bot.Send(
+		telebot.ChatID(chatID),
+		text,
+		&telebot.SendOptions{ThreadID: topicID})
+	if err != nil {
+		support.log.Error("Can`t post notice to the topic", "Error", err)
+	}
+}
+
+func (support *Support) topicByKey(key string) (entity.TopicData, error) {
+	topic, err := support.db.Topic(context.Background(), key)
+	if err != nil {
+		return entity.TopicData{}, err
+	}
+
+	if topic == "" {
+		return entity.TopicData{}, fmt.Errorf("topic %s not found", key)
+	}
+
+	jsonTopic, err := crypto.DecryptData(topic)
+	if err != nil {
+		return entity.TopicData{}, err
+	}
+
+	return entity.NewTopicFromJSON([]byte(jsonTopic))
+}
@@ -0,0 +1,135 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const ticketHistoryKey = "chatid{%d}:ticket:{%d}:history"
+
+var defaultNotePrefixes = []string{"//", "#note"}
+
+func(support *Support) History(chatID, number int64) ([]entity.HistoryEntry, error) {
+	records, err := support.db.History(
+		context.Background(),
+		fmt.Sprintf(ticketHistoryKey, chatID, number))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entity.HistoryEntry, 0, len(records))
+	for _, record := range records {
+		jsonEntry, err := crypto.DecryptData(record)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := entity.NewHistoryEntryFromJSON([]byte(jsonEntry))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (support *Support) addHistory(chatID int64, entry entity.HistoryEntry) error {
+	data, err := encoding.ToJSON(entry)
+	if err != nil {
+		return err
+	}
+
+	encryptEntry, err := crypto.EncryptData(data)
+	if err != nil {
+		return err
+	}
+
+	retention := time.Duration(support.settings.Tenant(chatID).Transcript.RetentionDays) * 24 * time.Hour
+	err = support.db.AddHistory(
+		context.Background(),
+		fmt.Sprintf(ticketHistoryKey, chatID, entry.Ticket),
+		encryptEntry,
+		retention)
+	if err != nil {
+		return err
+	}
+
+	support.indexText(chatID, entry.Ticket, entry.Text)
+	return nil
+}
+
+// isNote tells whether the agent message is an internal note: it starts
+// with a note prefix or replies to the pinned card.
+func (support *Support) isNote(supportMsg entity.SupportMessage, topicData entity.TopicData) bool {
+	if topicData.CardMessageID != 0 && supportMsg.ReplyToMessageID == topicData.CardMessageID {
+		return true
+	}
+
+	_, found := support.notePrefix(supportMsg)
+	return found
+}
+
+func (support *Support) notePrefix(supportMsg entity.SupportMessage) (string, bool) {
+	prefixes := support.settings.Tenant(supportMsg.ChatID).NotePrefixes
+	if len(prefixes) == 0 {
+		prefixes = defaultNotePrefixes
+	}
+
+	payload := strings.TrimSpace(supportMsg.Payload)
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(payload, prefix) {
+			return prefix, true
+		}
+	}
+
+	return "", false
+}
+
+// saveNote keeps the note in the ticket history, it is never sent to the user.
+func (support *Support) saveNote(supportMsg entity.SupportMessage, topicData entity.TopicData) error {
+	text := strings.TrimSpace(supportMsg.Payload)
+	if prefix, found := support.notePrefix(supportMsg); found {
+		text = strings.TrimSpace(strings.TrimPrefix(text, prefix))
+	}
+
+	return support.addHistory(
+		topicData.GroupChatID,
+		entity.NewHistoryEntry(
+			entity.HistoryNote,
+			topicData.Ticket,
+			supportMsg.MessageID,
+			supportMsg.Sender(),
+			text,
+			time.Now().Unix()))
+}
+
+func (support *Support) recordUserMessage(topicData entity.TopicData, telegramMessage entity.UserMessage) {
+	err := support.addHistory(
+		topicData.GroupChatID,
+		entity.NewUserHistoryEntry(topicData.Ticket, telegramMessage, time.Now().Unix()))
+	if err != nil {
+		support.log.Error("Can`t record the user message in the transcript", "Error", err)
+	}
+}
+
+func (support *Support) recordAgentReply(supportMsg entity.SupportMessage, topicData entity.TopicData, text string) {
+	err := support.addHistory(
+		topicData.GroupChatID,
+		entity.NewHistoryEntry(
+			entity.HistoryAgent,
+			topicData.Ticket,
+			supportMsg.MessageID,
+			supportMsg.Sender(),
+			text,
+			time.Now().Unix()))
+	if err != nil {
+		support.log.Error("Can`t record the agent reply in the transcript", "Error", err)
+	}
+}
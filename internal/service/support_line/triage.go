@@ -0,0 +1,364 @@
+package supportline
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/bot"
+	"github.com/behummble/support_line_bot/pkg/crypto"
+	"github.com/behummble/support_line_bot/pkg/encoding"
+)
+
+const (
+	triageKey = "chatid{%d}:user:{%d}:triage"
+	triageHeldKey = "chatid{%d}:user:{%d}:triage:held"
+	triageAction = "triage"
+	triagePendingLimit = 10
+	defaultTriageTimeout = 30 * time.Minute
+	defaultTriagePrompt = "What is your question about?"
+	defaultTriageError = "The answer doesn't look right, please try again."
+	triageExpired = "The menu has expired, please send your message again."
+	triageDropped = "The previous menu has expired and your earlier messages were not delivered. Please choose the topic and send them again."
+	triageHeldWindow = 24 * time.Hour
+)
+
+// triagePatterns caches the compiled field patterns by their source.
+var triagePatterns sync.Map
+
+// triage walks the user without a topic through the tenant triage. It
+// reports whether the triage is complete and the topic can be created.
+func (support *Support) triage(telegramMessage entity.UserMessage, bot *bot.Bot) (entity.TriageState, bool, error) {
+	settings := support.settings.Tenant(telegramMessage.GroupChatID).Triage
+	if !settings.Enabled || len(settings.Categories) == 0 {
+		return entity.NewTriageState(telegramMessage), true, nil
+	}
+
+	state, found, err := support.triageState(telegramMessage.GroupChatID, telegramMessage.UserID)
+	if err != nil {
+		return entity.TriageState{}, false, err
+	}
+
+	if !found {
+		support.notifyDroppedTriage(telegramMessage, bot)
+
+		state = entity.NewTriageState(telegramMessage)
+		err = support.saveTriageState(telegramMessage.GroupChatID, telegramMessage.UserID, state)
+		if err != nil {
+			return entity.TriageState{}, false, err
+		}
+
+		return state, false, support.sendTriageMenu(telegramMessage, settings, bot)
+	}
+
+	if state.Category == "" {
+		if len(state.Pending) < triagePendingLimit {
+			state.Pending = append(state.Pending, telegramMessage)
+		}
+		return state, false, support.saveTriageState(telegramMessage.GroupChatID, telegramMessage.UserID, state)
+	}
+
+	category, found := triageCategory(settings, state.Category)
+	if !found || state.Field >= len(category.Fields) {
+		return state, true, support.removeTriageState(telegramMessage.GroupChatID, telegramMessage.UserID)
+	}
+
+	field := category.Fields[state.Field]
+	answer := strings.TrimSpace(telegramMessage.Payload)
+	valid, err := validTriageAnswer(field, answer)
+	if err != nil {
+		support.log.Error("Invalid triage field pattern", "Field", field.Name, "Error", err)
+		valid = answer != ""
+	}
+
+	if !valid {
+		text := field.Error
+		if text == "" {
+			text = defaultTriageError
+		}
+		_, err = bot.Send(telebot.ChatID(telegramMessage.ChatID), text, &telebot.SendOptions{})
+		return state, false, err
+	}
+
+	state.Answers = append(state.Answers, entity.TriageAnswer{Name: field.Name, Value: answer})
+	state.Field++
+	if state.Field == len(category.Fields) {
+		return state, true, support.removeTriageState(telegramMessage.GroupChatID, telegramMessage.UserID)
+	}
+
+	err = support.saveTriageState(telegramMessage.GroupChatID, telegramMessage.UserID, state)
+	if err != nil {
+		return state, false, err
+	}
+
+	_, err = bot.Send(telebot.ChatID(telegramMessage.ChatID), category.Fields[state.Field].Prompt, &telebot.SendOptions{})
+	return state, false, err
+}
+
+// chooseCategory handles the category button of the triage menu. The state
+// is taken atomically, so of the concurrent taps only one goes on.
+func (support *Support) chooseCategory(callback entity.CallbackMessage, args []string, bot *bot.Bot) error {
+	if len(args) != 1 {
+		return fmt.Errorf("invalid triage callback %s", callback.Data)
+	}
+
+	settings := support.settings.Tenant(callback.GroupChatID).Triage
+	category, known := triageCategory(settings, args[0])
+	if !known {
+		return bot.Respond(callback.CallbackID, triageExpired)
+	}
+
+	state, found, err := support.takeTriageState(callback.GroupChatID, callback.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return bot.Respond(callback.CallbackID, triageExpired)
+	}
+
+	if state.Category != "" {
+		err = support.saveTriageState(callback.GroupChatID, callback.UserID, state)
+		if err != nil {
+			return err
+		}
+		return bot.Respond(callback.CallbackID, triageExpired)
+	}
+
+	state.Category = category.ID
+	err = bot.Respond(callback.CallbackID, category.Title)
+	if err != nil {
+		support.log.Error("Can`t answer triage callback", "Error", err)
+	}
+
+	_, err = bot.EditMessage(
+		&telebot.Message{ID: callback.MessageID, Chat: &telebot.Chat{ID: callback.ChatID}},
+		category.Title)
+	if err != nil {
+		support.log.Error("Can`t update triage menu", "Error", err)
+	}
+
+	if len(category.Fields) > 0 {
+		err = support.saveTriageState(callback.GroupChatID, callback.UserID, state)
+		if err != nil {
+			return err
+		}
+
+		_, err = bot.Send(telebot.ChatID(callback.ChatID), category.Fields[0].Prompt, &telebot.SendOptions{})
+		return err
+	}
+
+	err = support.removeTriageState(callback.GroupChatID, callback.UserID)
+	if err != nil {
+		return err
+	}
+
+	ready, err := support.offerFAQ(state, bot)
+	if err != nil || !ready {
+		return err
+	}
+
+	supportChat, err := bot.ChatByID(callback.GroupChatID)
+	if err != nil {
+		return err
+	}
+
+	return support.openTriagedTopic(state, bot, supportChat)
+}
+
+// notifyDroppedTriage tells the user that the triage expired before they
+// picked a category and the messages it held were dropped.
+func (support *Support) notifyDroppedTriage(telegramMessage entity.UserMessage, bot *bot.Bot) {
+	held, err := support.db.TakeUserState(
+		context.Background(),
+		fmt.Sprintf(triageHeldKey, telegramMessage.GroupChatID, telegramMessage.UserID))
+	if err != nil {
+		support.log.Error("Can`t check expired triage", "Error", err)
+		return
+	}
+
+	if held == "" {
+		return
+	}
+
+	_, err = bot.Send(telebot.ChatID(telegramMessage.ChatID), triageDropped, &telebot.SendOptions{})
+	if err != nil {
+		support.log.Error("Can`t notify user about expired triage", "Error", err)
+	}
+}
+
+func (support *Support) sendTriageMenu(telegramMessage entity.UserMessage, settings config.TriageConfig, bot *bot.Bot) error {
+	prompt := localized(settings.Prompt, telegramMessage.LanguageCode)
+	if prompt == "" {
+		prompt = defaultTriagePrompt
+	}
+
+	keyboard := make([][]telebot.InlineButton, 0, len(settings.Categories))
+	for _, category := range settings.Categories {
+		keyboard = append(keyboard, []telebot.InlineButton{{
+			Text: category.Title,
+			Data: entity.NewCallbackData(triageAction, category.ID),
+		}})
+	}
+
+	_, err := bot.Send(
+		telebot.ChatID(telegramMessage.ChatID),
+		prompt,
+		&telebot.SendOptions{
+			ReplyMarkup: &telebot.ReplyMarkup{InlineKeyboard: keyboard},
+		})
+	return err
+}
+
+// openTriagedTopic creates the topic for the messages held by the triage or the
+// FAQ offer.
+func (support *Support) openTriagedTopic(state entity.TriageState, bot *bot.Bot, supportChat *telebot.Chat) error {
+	err := support.createTopic(state.Pending[0], state, bot, supportChat)
+	if err != nil {
+		return err
+	}
+
+	return support.forwardPending(state, bot, supportChat)
+}
+
+// forwardPending forwards the messages the user sent during the triage
+// after the first one, which opened the topic.
+func (support *Support) forwardPending(state entity.TriageState, bot *bot.Bot, supportChat *telebot.Chat) error {
+	if len(state.Pending) < 2 {
+		return nil
+	}
+
+	first := state.Pending[0]
+	topicData, err := support.topicByKey(fmt.Sprintf(topicUserKey, first.GroupChatID, first.UserID))
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range state.Pending[1:] {
+		msg.GroupChatID = first.GroupChatID
+		err = support.transferMessageToTopic(topicData, msg, bot, supportChat)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (support *Support) triageState(chatID, userID int64) (entity.TriageState, bool, error) {
+	data, err := support.db.UserState(context.Background(), fmt.Sprintf(triageKey, chatID, userID))
+	if err != nil || data == "" {
+		return entity.TriageState{}, false, err
+	}
+
+	return decryptTriageState(data)
+}
+
+func (support *Support) takeTriageState(chatID, userID int64) (entity.TriageState, bool, error) {
+	data, err := support.db.TakeUserState(context.Background(), fmt.Sprintf(triageKey, chatID, userID))
+	if err != nil || data == "" {
+		return entity.TriageState{}, false, err
+	}
+
+	return decryptTriageState(data)
+}
+
+func decryptTriageState(data string) (entity.TriageState, bool, error) {
+	jsonState, err := crypto.DecryptData(data)
+	if err != nil {
+		return entity.TriageState{}, false, err
+	}
+
+	state, err := entity.NewTriageStateFromJSON([]byte(jsonState))
+	return state, err == nil, err
+}
+
+func (support *Support) saveTriageState(chatID, userID int64, state entity.TriageState) error {
+	data, err := encoding.ToJSON(state)
+	if err != nil {
+		return err
+	}
+
+	encryptState, err := crypto.EncryptData(data)
+	if err != nil {
+		return err
+	}
+
+	timeout := defaultTriageTimeout
+	if minutes := support.settings.Tenant(chatID).Triage.TimeoutMinutes; minutes > 0 {
+		timeout = time.Duration(minutes) * time.Minute
+	}
+
+	err = support.db.SetUserState(
+		context.Background(),
+		fmt.Sprintf(triageKey, chatID, userID),
+		encryptState,
+		timeout)
+	if err != nil {
+		return err
+	}
+
+	// The held mark outlives the state to tell the user their messages
+	// were dropped when it expires.
+	return support.db.SetUserState(
+		context.Background(),
+		fmt.Sprintf(triageHeldKey, chatID, userID),
+		"1",
+		timeout+triageHeldWindow)
+}
+
+func (support *Support) removeTriageState(chatID, userID int64) error {
+	err := support.db.DeleteKey(context.Background(), fmt.Sprintf(triageKey, chatID, userID))
+	if err != nil {
+		return err
+	}
+
+	return support.db.DeleteKey(context.Background(), fmt.Sprintf(triageHeldKey, chatID, userID))
+}
+
+func triageCategory(settings config.TriageConfig, id string) (config.TriageCategory, bool) {
+	for _, category := range settings.Categories {
+		if category.ID == id {
+			return category, true
+		}
+	}
+	return config.TriageCategory{}, false
+}
+
+func validTriageAnswer(field config.TriageField, answer string) (bool, error) {
+	if answer == "" {
+		return false, nil
+	}
+
+	if field.Pattern == "" {
+		return true, nil
+	}
+
+	pattern, err := triagePattern(field.Pattern)
+	if err != nil {
+		return false, err
+	}
+
+	return pattern.MatchString(answer), nil
+}
+
+func triagePattern(source string) (*regexp.Regexp, error) {
+	if cached, ok := triagePatterns.Load(source); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	triagePatterns.Store(source, pattern)
+	return pattern, nil
+}
@@ -0,0 +1,38 @@
+package supportline
+
+import (
+	"testing"
+
+	"github.com/behummble/support_line_bot/internal/config"
+)
+
+func TestValidTriageAnswer(t *testing.T) {
+	check := func(field config.TriageField, answer string, want bool) {
+		t.Helper()
+		got, err := validTriageAnswer(field, answer)
+		if err != nil {
+			t.Fatalf("%s %q: %v", field.Name, answer, err)
+		}
+		if got != want {
+			t.Errorf("%s %q is valid %t, want %t", field.Name, answer, got, want)
+		}
+	}
+
+	details := config.TriageField{Name: "Details"}
+	check(details, "anything", true)
+	check(details, "", false)
+
+	order := config.TriageField{Name: "Order number", Pattern: "^[0-9]{4,12}$"}
+	check(order, "123456", true)
+	check(order, "123", false)
+	check(order, "12ab56", false)
+
+	email := config.TriageField{Name: "Email", Pattern: `^[^@\s]+@[^@\s]+\.[^@\s]+$`}
+	check(email, "user@example.com", true)
+	check(email, "user@", false)
+
+	_, err := validTriageAnswer(config.TriageField{Name: "Broken", Pattern: "("}, "text")
+	if err == nil {
+		t.Error("the broken pattern is accepted")
+	}
+}
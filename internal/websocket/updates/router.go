@@ -1,37 +1,69 @@
 package updates
 
 import(
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"log/slog"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 	"golang.org/x/net/websocket"
+	"github.com/behummble/support_line_bot/internal/entity"
+	"github.com/behummble/support_line_bot/internal/service/export"
 	"github.com/behummble/support_line_bot/internal/service/support_line"
 )
 
 const (
 	userMessages = "/user/message"
+	userCallbacks = "/user/callback"
 	supportMessages = "/support/message"
+	supportCallbacks = "/support/callback"
 	ping = "/ping"
+	deliveries = "/support/delivery"
+	roster = "/support/roster"
+	csatReport = "/reports/csat"
+	macros = "/support/macros"
+	transcripts = "/support/export"
+	search = "/support/search"
+	bans = "/support/bans"
+	faq = "/support/faq"
+	faqReport = "/reports/faq"
 )
 
 type Router struct {
 	supportService *supportline.Support
 	log *slog.Logger
 	mux *http.ServeMux
+	adminToken string
 }
 
-func New(log *slog.Logger, support *supportline.Support) *Router {
+func New(log *slog.Logger, support *supportline.Support, adminToken string) *Router {
 	m := http.NewServeMux()
 	return &Router{
 		supportService: support,
 		log: log,
 		mux: m,
+		adminToken: adminToken,
 	}
 }
 
 func (r *Router) Register() {
 	r.mux.Handle(userMessages, websocket.Handler(r.userMessage))
+	r.mux.Handle(userCallbacks, websocket.Handler(r.userCallback))
 	r.mux.Handle(supportMessages, websocket.Handler(r.supportMessage))
+	r.mux.Handle(supportCallbacks, websocket.Handler(r.supportCallback))
+	r.mux.HandleFunc(deliveries, r.deliveries)
+	r.mux.HandleFunc(roster, r.roster)
+	r.mux.HandleFunc(csatReport, r.csatReport)
+	r.mux.HandleFunc(macros, r.admin(r.macros))
+	r.mux.HandleFunc(transcripts, r.admin(r.export))
+	r.mux.HandleFunc(search, r.admin(r.search))
+	r.mux.HandleFunc(bans, r.admin(r.bans))
+	r.mux.HandleFunc(faq, r.admin(r.faq))
+	r.mux.HandleFunc(faqReport, r.faqReport)
 	r.mux.Handle(ping, websocket.Handler(
 		func(ws *websocket.Conn) {
 			websocket.Message.Send(ws, "pong")
@@ -46,13 +78,67 @@ func (r *Router) Serve(host string, port int) {
 	}
 }
 
+// admin lets the request through with the admin token for any tenant the
+// bot serves, or with the API token of the requested tenant.
+func (r *Router) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid chat", http.StatusBadRequest)
+			return
+		}
+
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !sameToken(token, r.adminToken) && !sameToken(token, r.supportService.APIToken(chatID)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		known, err := r.supportService.KnownTenant(chatID)
+		if err != nil {
+			r.log.Error("Check support tenant", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !known {
+			http.Error(w, "unknown chat", http.StatusNotFound)
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+// sameToken compares the tokens in constant time, an empty expected token
+// matches nothing.
+func sameToken(token, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
 func (r *Router) userMessage(ws *websocket.Conn) {
 	var data []byte
 	err := websocket.Message.Receive(ws, &data)
 	if err == nil {
 		r.supportService.ProcessUserMessage(data)
 	} else {
-		r.log.Error("HandleWebSocketMessage", err)
+		r.log.Error("HandleWebSocketMessage", "Error", err)
+	}
+}
+
+func (r *Router) userCallback(ws *websocket.Conn) {
+	var data []byte
+	err := websocket.Message.Receive(ws, &data)
+	if err == nil {
+		r.supportService.ProcessUserCallback(data)
+	} else {
+		r.log.Error("HandleWebSocketMessage", "Error", err)
 	}
 }
 
@@ -62,6 +148,383 @@ func (r *Router) supportMessage(ws *websocket.Conn) {
 	if err == nil {
 		r.supportService.ProcessSupportMessage(data)
 	} else {
-		r.log.Error("HandleWebSocketMessage", err)
+		r.log.Error("HandleWebSocketMessage", "Error", err)
+	}
+}
+
+func (r *Router) supportCallback(ws *websocket.Conn) {
+	var data []byte
+	err := websocket.Message.Receive(ws, &data)
+	if err == nil {
+		r.supportService.ProcessSupportCallback(data)
+	} else {
+		r.log.Error("HandleWebSocketMessage", "Error", err)
+	}
+}
+
+func (r *Router) deliveries(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	topicID, err := strconv.Atoi(req.URL.Query().Get("topic"))
+	if err != nil {
+		http.Error(w, "invalid topic", http.StatusBadRequest)
+		return
+	}
+
+	result, err := r.supportService.Deliveries(chatID, topicID)
+	if err != nil {
+		r.log.Error("Get topic deliveries", "Error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.writeJSON(w, result)
+}
+
+func (r *Router) roster(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	result, err := r.supportService.Roster(chatID)
+	if err != nil {
+		r.log.Error("Get support roster", "Error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.writeJSON(w, result)
+}
+
+// bans lists the active bans on GET and lifts the ban of the user on DELETE.
+func (r *Router) bans(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		result, err := r.supportService.Bans(chatID)
+		if err != nil {
+			r.log.Error("Get support bans", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r.writeJSON(w, result)
+	case http.MethodDelete:
+		userID, err := strconv.ParseInt(req.URL.Query().Get("user"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusBadRequest)
+			return
+		}
+
+		banned, err := r.supportService.Unban(chatID, userID)
+		if err != nil {
+			r.log.Error("Remove support ban", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !banned {
+			http.Error(w, "the user is not banned", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Router) csatReport(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parsePeriod(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := r.supportService.CSATReport(chatID, from, to)
+	if err != nil {
+		r.log.Error("Get CSAT report", "Error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.writeJSON(w, result)
+}
+
+// macros lists the tenant macros on GET, saves the macro from the JSON body
+// on POST and removes the named macro on DELETE.
+func (r *Router) macros(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		result, err := r.supportService.Macros(chatID)
+		if err != nil {
+			r.log.Error("Get support macros", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r.writeJSON(w, result)
+	case http.MethodPost:
+		var macro entity.Macro
+		err = json.NewDecoder(req.Body).Decode(&macro)
+		if err != nil {
+			http.Error(w, "invalid macro", http.StatusBadRequest)
+			return
+		}
+
+		err = r.supportService.SaveMacro(chatID, macro)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err = r.supportService.RemoveMacro(chatID, req.URL.Query().Get("name"))
+		if err != nil {
+			r.log.Error("Remove support macro", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Router) faqReport(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parsePeriod(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := r.supportService.FAQReport(chatID, from, to)
+	if err != nil {
+		r.log.Error("Get FAQ report", "Error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.writeJSON(w, result)
+}
+
+// faq lists the knowledge base articles on GET, saves the article from the
+// JSON body on POST and removes the article by id on DELETE.
+func (r *Router) faq(w http.ResponseWriter, req *http.Request) {
+	chatID, err := strconv.ParseInt(req.URL.Query().Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		result, err := r.supportService.Articles(chatID)
+		if err != nil {
+			r.log.Error("Get support articles", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r.writeJSON(w, result)
+	case http.MethodPost:
+		var article entity.Article
+		err = json.NewDecoder(req.Body).Decode(&article)
+		if err != nil {
+			http.Error(w, "invalid article", http.StatusBadRequest)
+			return
+		}
+
+		err = r.supportService.SaveArticle(chatID, article)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err = r.supportService.RemoveArticle(chatID, req.URL.Query().Get("id"))
+		if err != nil {
+			r.log.Error("Remove support article", "Error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Router) search(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	chatID, err := strconv.ParseInt(query.Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	searchQuery := entity.SearchQuery{
+		Text: query.Get("q"),
+		User: query.Get("user"),
+		Status: query.Get("status"),
+	}
+
+	searchQuery.From, searchQuery.To, err = parsePeriod(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if value := query.Get("limit"); value != "" {
+		searchQuery.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := r.supportService.Search(chatID, searchQuery)
+	if errors.Is(err, supportline.ErrEmptySearch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		r.log.Error("Search tickets", "Error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.writeJSON(w, result)
+}
+
+// parsePeriod reads the optional from and to dates, the to date is inclusive.
+func parsePeriod(req *http.Request) (time.Time, time.Time, error) {
+	return export.ParsePeriod(req.URL.Query().Get("from"), req.URL.Query().Get("to"))
+}
+
+// export renders the transcripts of the ticket or of the tickets created in
+// the period, bundle=1 returns a zip archive with the attachments.
+func (r *Router) export(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	chatID, err := strconv.ParseInt(query.Get("chat"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chat", http.StatusBadRequest)
+		return
+	}
+
+	var filter entity.TranscriptFilter
+	if value := query.Get("ticket"); value != "" {
+		filter.Ticket, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid ticket", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter.From, filter.To, err = parsePeriod(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = export.FormatJSON
+	}
+
+	if !export.IsFormat(format) {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	bundle := query.Get("bundle") == "1"
+	name := "transcript." + format
+	contentType := export.ContentType(format)
+	if bundle {
+		name = "transcript.zip"
+		contentType = "application/zip"
+	}
+
+	out := &attachmentWriter{w: w, name: name, contentType: contentType}
+	err = r.supportService.Export(out, chatID, filter, format, bundle)
+	if err != nil {
+		r.log.Error("Export transcripts", "Error", err)
+		if !out.started {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The status is already sent, drop the connection so the client
+		// doesn't take the truncated file for a complete one.
+		panic(http.ErrAbortHandler)
+	}
+
+	out.start()
+}
+
+// attachmentWriter streams the export to the response and sends the
+// attachment headers with the first bytes, so an error before them can
+// still be answered with an error status.
+type attachmentWriter struct {
+	w http.ResponseWriter
+	name string
+	contentType string
+	started bool
+}
+
+func (writer *attachmentWriter) start() {
+	if writer.started {
+		return
+	}
+
+	writer.started = true
+	writer.w.Header().Set("Content-Type", writer.contentType)
+	writer.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, writer.name))
+	writer.w.WriteHeader(http.StatusOK)
+}
+
+func (writer *attachmentWriter) Write(data []byte) (int, error) {
+	writer.start()
+	return writer.w.Write(data)
+}
+
+func (r *Router) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		r.log.Error("Write JSON response", "Error", err)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,52 @@
+package updates
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/behummble/support_line_bot/internal/config"
+	"github.com/behummble/support_line_bot/internal/service/support_line"
+)
+
+func TestAdminAuthorization(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	settings := config.SupportConfig{Tenants: []config.TenantConfig{{ChatID: -200, APIToken: "tenant-secret"}}}
+	router := New(log, supportline.New(log, nil, nil, "", -100, 10, settings), "admin-secret")
+	handler := router.admin(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	status := func(chat, authorization string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/support/bans?chat="+chat+"&user=1", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := status("-100", ""); code != http.StatusUnauthorized {
+		t.Errorf("request without a token got %d", code)
+	}
+
+	if code := status("-100", "Bearer wrong"); code != http.StatusForbidden {
+		t.Errorf("request with a wrong token got %d", code)
+	}
+
+	if code := status("-100", "Bearer tenant-secret"); code != http.StatusForbidden {
+		t.Errorf("tenant token opened another chat, got %d", code)
+	}
+
+	if code := status("-200", "Bearer tenant-secret"); code != http.StatusNoContent {
+		t.Errorf("tenant token for its own chat got %d", code)
+	}
+
+	if code := status("-100", "Bearer admin-secret"); code != http.StatusNoContent {
+		t.Errorf("admin token got %d", code)
+	}
+}
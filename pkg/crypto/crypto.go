@@ -8,6 +8,8 @@ import (
 	"errors"
 	"io"
 	"crypto/rand"
+	"crypto/hmac"
+	"crypto/sha256"
 )
 
 func DecryptData(data string) (string, error) {
@@ -65,6 +67,44 @@ func EncryptData(data []byte) (string, error) {
 	return hex.EncodeToString(ciphertext), nil
 }
 
+// EncryptBytes seals binary data with AES-GCM, the nonce is put in front of
+// the ciphertext. Unlike EncryptData it keeps any trailing bytes intact.
+func EncryptBytes(data []byte) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func DecryptBytes(data []byte) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(os.Getenv("CRYPTO_KEY")))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
 func addPaddingBytes(data []byte) []byte {
 	l := 16 - len(data) % 16
 	padding := make([]byte, l)
@@ -79,4 +119,12 @@ func removePaddingBytes(data []byte) []byte {
 	}
 
 	return data[:len(data)-l]
-}
\ No newline at end of file
+}
+
+// HashData returns the keyed hash of the data, it lets look up values
+// without storing them in clear text.
+func HashData(data string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("CRYPTO_KEY")))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptBytes(t *testing.T) {
+	t.Setenv("CRYPTO_KEY", "0123456789abcdef0123456789abcdef")
+
+	// A gzip stream ends with the length of the data, the small trailing
+	// bytes must survive the round trip.
+	data := append(bytes.Repeat([]byte{0x1f}, 30), 0x02, 0x00)
+	sealed, err := EncryptBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, data) {
+		t.Fatal("the sealed data contains the plain text")
+	}
+
+	opened, err := DecryptBytes(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Errorf("decrypted %x, want %x", opened, data)
+	}
+
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := DecryptBytes(sealed); err == nil {
+		t.Error("tampered data was decrypted")
+	}
+}